@@ -3,102 +3,467 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"sync"
+	"time"
 )
 
-// These constants determines access levels
-// You can compare it numerically as levels with more rights are always numerically higher
-// But the exact values of constants are not guaranteed, so always use constants
-// Note: Due to app design, there must be only one user with AccessLevelAdmin,
-// and it should be impossible to change him from the app runtime
-const (
-	AccessLevelBanned = iota // Must be lowest
-	AccessLevelGuest
-	AccessLevelOperator
-	AccessLevelAdmin // Must be highest
-)
+var AdminMutationError = errors.New("admin's role is immutable")
+
+// Grant is a role given to user along with who gave it, when and why
+type Grant struct {
+	Role      string    `json:"role"`
+	GrantedBy int64     `json:"granted_by,omitempty"`
+	GrantedAt time.Time `json:"granted_at"` // zero for grants migrated from files without it
+	Note      string    `json:"note,omitempty"`
+	// nil for permanent grants
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// whether admin has been reminded that the grant expires soon
+	Reminded bool `json:"reminded,omitempty"`
+}
 
-func ValidLevelToSet(level int) bool {
-	return AccessLevelBanned <= level && level < AccessLevelAdmin
+// Expired tells if temporary grant has expired by now. Expired grants give no role even if they are not removed yet.
+func (g *Grant) Expired(now time.Time) bool {
+	return g.ExpiresAt != nil && !g.ExpiresAt.After(now)
 }
 
-var InvalidAccessLevelError = errors.New("invalid access level value")
-var AdminMutationError = errors.New("admin's access level is immutable")
+// GrantRecord is a grant of user in network, networkId is empty for grants in app
+type GrantRecord struct {
+	UserId    int64
+	NetworkId string
+	Grant
+}
 
-// AccessManager says what access level given telegram user has.
-// You should always use `AccessLevel*` constants as exact values may vary then
+// AccessManager says what role given telegram user has and what the user is permitted to do.
+// Role may be global or scoped to a network; network-scoped role, if set, overrides global one in that network.
+// Globally banned users are banned in every network.
 type AccessManager interface {
-	// Returns value is an `AccessLevel*` constant
-	GetAccessLevel(id int64) int
-	// accessLevel must be an `AccessLevel*` constant
-	SetAccessLevel(id int64, accessLevel int) error
+	// Returns global role, RoleGuest if user has none
+	GetRole(id int64) string
+	// Grant with RoleGuest removes user's global role. GrantedAt is set to current time if zero.
+	SetRole(id int64, grant Grant) error
+	// Returns role in given network, falls back to global role if there is no network-scoped one
+	GetNetworkRole(id int64, networkId string) string
+	// Grant with RoleGuest removes network-scoped role, so that global one applies
+	SetNetworkRole(id int64, networkId string, grant Grant) error
+	// Tells if user has permission in given network or globally if networkId is empty
+	HasPermission(id int64, networkId string, permission Permission) bool
+	// Returns all grants with ExpiresAt set, expired ones that are not removed yet included
+	TemporaryGrants() ([]GrantRecord, error)
+	// Removes grants expired by now and returns them
+	RemoveExpiredGrants(now time.Time) ([]GrantRecord, error)
+	// Sets Reminded of user's grant in network (in app if networkId is empty)
+	MarkReminded(id int64, networkId string) error
+	// Returns users having permission in given network, admin included
+	UsersWithPermission(networkId string, permission Permission) ([]int64, error)
+	// Releases the storage
+	Close() error
 }
 
+// Storages of AccessManager, see BotConfig.OpsBackend
+const (
+	OpsBackendFile   = "file"
+	OpsBackendSQLite = "sqlite"
+)
+
+var UnknownOpsBackend = errors.New("unknown ops backend")
+
+// AccessManagerWithFileStorage is safe for concurrent use.
+// It holds a lock on `<filepath>.lock` until closed, so that other instances of the bot can't use the same file.
 type AccessManagerWithFileStorage struct {
-	adminId   int64
-	accessMap map[int64]int
-	filepath  string
+	mu              sync.RWMutex
+	adminId         int64
+	roles           *Roles
+	grantMap        map[int64]Grant
+	networkGrantMap map[string]map[int64]Grant // network id -> user id -> grant
+	filepath        string
+	lock            *fileLock
 }
 
-func NewAccessManagerWithFileStorage(adminId int64, filepath string) (*AccessManagerWithFileStorage, error) {
-	m := make(map[int64]int, 0)
+// Version of ops file schema written by the bot.
+// Version 0 is a bare map of numeric access levels, version 1 is the same in "users" and "networks"
+// with either levels or role names; neither of them has "version" field.
+// Version 3 adds temporary grants, it is incompatible with bots knowing version 2 only, as they would make the grants permanent.
+const accessFileVersion = 3
 
-	if _, err := os.Stat(filepath); os.IsExist(err) {
-		fileData, err := ioutil.ReadFile(filepath)
-		if err != nil {
+// Content of ops file
+type accessFileData struct {
+	Version  int                        `json:"version"`
+	Users    map[int64]Grant            `json:"users"`
+	Networks map[string]map[int64]Grant `json:"networks,omitempty"`
+}
+
+// Numeric access levels of old ops files
+var legacyLevelRoles = map[int]string{
+	0: RoleBanned,
+	1: RoleGuest,
+	2: RoleOperator,
+}
+
+func NewAccessManagerWithFileStorage(adminId int64, roles *Roles, filepath string) (*AccessManagerWithFileStorage, error) {
+	lock, err := lockFile(filepath + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("ops file %s: %w", filepath, err)
+	}
+	a, err := loadAccessManagerWithFileStorage(adminId, roles, filepath)
+	if err != nil {
+		_ = lock.Unlock()
+		return nil, fmt.Errorf("ops file %s: %w", filepath, err)
+	}
+	a.lock = lock
+	return a, nil
+}
+
+func loadAccessManagerWithFileStorage(adminId int64, roles *Roles, filepath string) (*AccessManagerWithFileStorage, error) {
+	a := &AccessManagerWithFileStorage{
+		adminId:         adminId,
+		roles:           roles,
+		grantMap:        make(map[int64]Grant),
+		networkGrantMap: make(map[string]map[int64]Grant),
+		filepath:        filepath,
+	}
+
+	fileData, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, version, err := decodeAccessFile(fileData)
+	if err != nil {
+		return nil, err
+	}
+	for id, grant := range data.Users {
+		if a.isAdminGrant(id, "", grant) {
+			continue
+		}
+		if err = a.validGrant(id, grant); err != nil {
 			return nil, err
 		}
-		err = json.Unmarshal(fileData, &m)
-		if err != nil {
+		a.grantMap[id] = grant
+	}
+	for networkId, users := range data.Networks {
+		a.networkGrantMap[networkId] = make(map[int64]Grant)
+		for id, grant := range users {
+			if a.isAdminGrant(id, networkId, grant) {
+				continue
+			}
+			if err = a.validGrant(id, grant); err != nil {
+				return nil, err
+			}
+			a.networkGrantMap[networkId][id] = grant
+		}
+	}
+
+	if version < accessFileVersion {
+		// old file is kept in case the bot is downgraded
+		backup := fmt.Sprintf("%s.v%d.bak", filepath, version)
+		if err = writeFileAtomic(backup, fileData, 0644); err != nil {
 			return nil, err
 		}
-		for _, level := range m {
-			if !ValidLevelToSet(level) {
+		if err = a.commit(); err != nil {
+			return nil, err
+		}
+		log.Printf("Ops file %s migrated from version %d to %d, old one is saved to %s",
+			filepath, version, accessFileVersion, backup)
+	}
+	return a, nil
+}
+
+// decodeAccessFile decodes ops file of any known version and returns it converted to the current one
+func decodeAccessFile(fileData []byte) (*accessFileData, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(fileData, &fields); err != nil {
+		return nil, 0, err
+	}
+
+	if _, found := fields["version"]; found {
+		var data accessFileData
+		if err := json.Unmarshal(fileData, &data); err != nil {
+			return nil, 0, err
+		}
+		// version 2 differs only in lack of temporary grants
+		if data.Version != 2 && data.Version != accessFileVersion {
+			return nil, 0, fmt.Errorf("unsupported version %d", data.Version)
+		}
+		version := data.Version
+		data.Version = accessFileVersion
+		return &data, version, nil
+	}
+
+	var raw struct {
+		Users    map[int64]json.RawMessage            `json:"users"`
+		Networks map[string]map[int64]json.RawMessage `json:"networks"`
+	}
+	version := 1
+	var err error
+	if _, found := fields["users"]; found {
+		err = json.Unmarshal(fileData, &raw)
+	} else {
+		version = 0
+		err = json.Unmarshal(fileData, &raw.Users)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	data := &accessFileData{Version: accessFileVersion, Networks: make(map[string]map[int64]Grant)}
+	data.Users, err = decodeLegacyRoles(raw.Users)
+	if err != nil {
+		return nil, 0, err
+	}
+	for networkId, users := range raw.Networks {
+		data.Networks[networkId], err = decodeLegacyRoles(users)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return data, version, nil
+}
+
+// decodeLegacyRoles accepts both role names and numeric levels, guests are dropped
+func decodeLegacyRoles(raw map[int64]json.RawMessage) (map[int64]Grant, error) {
+	m := make(map[int64]Grant, len(raw))
+	for id, value := range raw {
+		var role string
+		if json.Unmarshal(value, &role) != nil {
+			var level int
+			if err := json.Unmarshal(value, &level); err != nil {
 				return nil, errors.New("file corrupted")
 			}
+			var found bool
+			if role, found = legacyLevelRoles[level]; !found {
+				return nil, errors.New("file corrupted")
+			}
+		}
+		if role != RoleGuest {
+			m[id] = Grant{Role: role}
+		}
+	}
+	return m, nil
+}
+
+// isAdminGrant tells if grant is given to admin, e.g. one who had a role before admin_id was changed.
+// Such grants are dropped, as admin's role is immutable.
+func (a *AccessManagerWithFileStorage) isAdminGrant(id int64, networkId string, grant Grant) bool {
+	if id != a.adminId {
+		return false
+	}
+	scope := "in app"
+	if len(networkId) > 0 {
+		scope = "in network " + networkId
+	}
+	log.Printf("Ops file %s: dropping role %q %s of admin %d", a.filepath, grant.Role, scope, id)
+	return true
+}
+
+func (a *AccessManagerWithFileStorage) validGrant(id int64, grant Grant) error {
+	if !a.roles.Assignable(grant.Role) || grant.Role == RoleGuest {
+		return fmt.Errorf("file corrupted: user %d has invalid role %q", id, grant.Role)
+	}
+	return nil
+}
+
+func (a *AccessManagerWithFileStorage) GetRole(id int64) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.role(id)
+}
+
+func (a *AccessManagerWithFileStorage) role(id int64) string {
+	if id == a.adminId {
+		return RoleAdmin
+	}
+	grant, found := a.grantMap[id]
+	if !found || grant.Expired(time.Now()) {
+		return RoleGuest
+	}
+	return grant.Role
+}
+
+func (a *AccessManagerWithFileStorage) SetRole(id int64, grant Grant) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if id == a.adminId {
+		return AdminMutationError
+	}
+	if !a.roles.Assignable(grant.Role) {
+		return InvalidRoleError
+	}
+	// RoleGuest is default value
+	if grant.Role == RoleGuest {
+		delete(a.grantMap, id)
+	} else {
+		if grant.GrantedAt.IsZero() {
+			grant.GrantedAt = time.Now().UTC()
 		}
-		delete(m, adminId)
+		a.grantMap[id] = grant
 	}
+	return a.commit()
+}
 
-	return &AccessManagerWithFileStorage{
-		adminId:   adminId,
-		accessMap: m,
-		filepath:  filepath,
-	}, nil
+func (a *AccessManagerWithFileStorage) GetNetworkRole(id int64, networkId string) string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.networkRole(id, networkId)
 }
 
-func (a AccessManagerWithFileStorage) GetAccessLevel(id int64) int {
+func (a *AccessManagerWithFileStorage) networkRole(id int64, networkId string) string {
 	if id == a.adminId {
-		return AccessLevelAdmin
+		return RoleAdmin
 	}
-	level, found := a.accessMap[id]
-	if !found {
-		return AccessLevelGuest
+	grant, found := a.networkGrantMap[networkId][id]
+	if !found || grant.Expired(time.Now()) {
+		return a.role(id)
 	}
-	return level
+	return grant.Role
 }
 
-func (a AccessManagerWithFileStorage) SetAccessLevel(id int64, accessLevel int) error {
+func (a *AccessManagerWithFileStorage) SetNetworkRole(id int64, networkId string, grant Grant) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	if id == a.adminId {
 		return AdminMutationError
 	}
-	if accessLevel < AccessLevelBanned || accessLevel >= AccessLevelAdmin {
-		return InvalidAccessLevelError
+	if !a.roles.Assignable(grant.Role) {
+		return InvalidRoleError
 	}
-	// AccessLevelGuest is default value
-	if accessLevel == AccessLevelGuest {
-		delete(a.accessMap, id)
+	// RoleGuest means "same as global role"
+	if grant.Role == RoleGuest {
+		delete(a.networkGrantMap[networkId], id)
+		if len(a.networkGrantMap[networkId]) == 0 {
+			delete(a.networkGrantMap, networkId)
+		}
 	} else {
-		a.accessMap[id] = accessLevel
+		if _, found := a.networkGrantMap[networkId]; !found {
+			a.networkGrantMap[networkId] = make(map[int64]Grant)
+		}
+		if grant.GrantedAt.IsZero() {
+			grant.GrantedAt = time.Now().UTC()
+		}
+		a.networkGrantMap[networkId][id] = grant
 	}
 	return a.commit()
 }
 
-func (a AccessManagerWithFileStorage) commit() error {
-	fileData, err := json.Marshal(a.accessMap)
+func (a *AccessManagerWithFileStorage) HasPermission(id int64, networkId string, permission Permission) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hasPermission(id, networkId, permission)
+}
+
+// hasPermission is HasPermission for a.mu locked
+func (a *AccessManagerWithFileStorage) hasPermission(id int64, networkId string, permission Permission) bool {
+	globalRole := a.role(id)
+	if globalRole == RoleBanned {
+		return false
+	}
+	if len(networkId) == 0 {
+		return a.roles.Has(globalRole, permission)
+	}
+	return a.roles.Has(a.networkRole(id, networkId), permission)
+}
+
+func (a *AccessManagerWithFileStorage) TemporaryGrants() ([]GrantRecord, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var records []GrantRecord
+	a.forEachGrant(func(record GrantRecord) {
+		if record.ExpiresAt != nil {
+			records = append(records, record)
+		}
+	})
+	return records, nil
+}
+
+func (a *AccessManagerWithFileStorage) RemoveExpiredGrants(now time.Time) ([]GrantRecord, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var expired []GrantRecord
+	a.forEachGrant(func(record GrantRecord) {
+		if record.Expired(now) {
+			expired = append(expired, record)
+		}
+	})
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	for _, record := range expired {
+		if len(record.NetworkId) == 0 {
+			delete(a.grantMap, record.UserId)
+			continue
+		}
+		delete(a.networkGrantMap[record.NetworkId], record.UserId)
+		if len(a.networkGrantMap[record.NetworkId]) == 0 {
+			delete(a.networkGrantMap, record.NetworkId)
+		}
+	}
+	return expired, a.commit()
+}
+
+func (a *AccessManagerWithFileStorage) MarkReminded(id int64, networkId string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	grants := a.grantMap
+	if len(networkId) > 0 {
+		grants = a.networkGrantMap[networkId]
+	}
+	grant, found := grants[id]
+	if !found {
+		return nil
+	}
+	grant.Reminded = true
+	grants[id] = grant
+	return a.commit()
+}
+
+func (a *AccessManagerWithFileStorage) UsersWithPermission(networkId string, permission Permission) ([]int64, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	users := []int64{a.adminId}
+	for id := range a.grantMap {
+		if _, found := a.networkGrantMap[networkId][id]; !found && a.hasPermission(id, networkId, permission) {
+			users = append(users, id)
+		}
+	}
+	for id := range a.networkGrantMap[networkId] {
+		if a.hasPermission(id, networkId, permission) {
+			users = append(users, id)
+		}
+	}
+	return users, nil
+}
+
+// forEachGrant calls f for grants in app and in every network, a.mu must be locked
+func (a *AccessManagerWithFileStorage) forEachGrant(f func(record GrantRecord)) {
+	for id, grant := range a.grantMap {
+		f(GrantRecord{UserId: id, Grant: grant})
+	}
+	for networkId, users := range a.networkGrantMap {
+		for id, grant := range users {
+			f(GrantRecord{UserId: id, NetworkId: networkId, Grant: grant})
+		}
+	}
+}
+
+// commit saves roles to file, a.mu must be locked
+func (a *AccessManagerWithFileStorage) commit() error {
+	fileData, err := json.MarshalIndent(&accessFileData{
+		Version:  accessFileVersion,
+		Users:    a.grantMap,
+		Networks: a.networkGrantMap,
+	}, "", "  ")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(a.filepath, fileData, 644)
+	return writeFileAtomic(a.filepath, fileData, 0644)
+}
+
+// Close releases lock on the file
+func (a *AccessManagerWithFileStorage) Close() error {
+	return a.lock.Unlock()
 }
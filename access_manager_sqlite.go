@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+)
+
+// Schema of the database, statement i migrates it from version i to i+1 (see `PRAGMA user_version`).
+// Grants are never deleted: a grant is revoked when user gets another role or becomes a guest, so that history is kept.
+var sqliteAccessMigrations = [][]string{
+	{
+		`CREATE TABLE users (
+			id INTEGER PRIMARY KEY,
+			first_granted_at TEXT NOT NULL
+		)`,
+		`CREATE TABLE roles (
+			name TEXT PRIMARY KEY,
+			permissions TEXT NOT NULL
+		)`,
+		`CREATE TABLE grants (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			network_id TEXT NOT NULL DEFAULT '',
+			role TEXT NOT NULL REFERENCES roles(name),
+			granted_by INTEGER NOT NULL DEFAULT 0,
+			granted_at TEXT NOT NULL,
+			note TEXT NOT NULL DEFAULT '',
+			revoked_by INTEGER,
+			revoked_at TEXT
+		)`,
+		// at most one active grant per user in app (empty network_id) and in every network
+		`CREATE UNIQUE INDEX grants_active ON grants(user_id, network_id) WHERE revoked_at IS NULL`,
+	},
+	{
+		// temporary grants, expired ones are revoked with revoked_by = 0
+		`ALTER TABLE grants ADD COLUMN expires_at TEXT`,
+		`ALTER TABLE grants ADD COLUMN reminded INTEGER NOT NULL DEFAULT 0`,
+	},
+}
+
+// AccessManagerWithSQLite keeps roles in SQLite database along with history of grants.
+// Roles are defined in config, they are copied to the database on start; roles removed from config grant nothing.
+type AccessManagerWithSQLite struct {
+	adminId int64
+	roles   *Roles
+	db      *sql.DB
+}
+
+func NewAccessManagerWithSQLite(adminId int64, roles *Roles, filepath string) (*AccessManagerWithSQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+filepath+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer anyway
+	db.SetMaxOpenConns(1)
+
+	a := &AccessManagerWithSQLite{
+		adminId: adminId,
+		roles:   roles,
+		db:      db,
+	}
+	if err = a.migrate(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ops database %s: %w", filepath, err)
+	}
+	if err = a.syncRoles(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ops database %s: %w", filepath, err)
+	}
+	return a, nil
+}
+
+func (a *AccessManagerWithSQLite) migrate() error {
+	var version int
+	if err := a.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteAccessMigrations) {
+		return fmt.Errorf("unsupported schema version %d", version)
+	}
+	for ; version < len(sqliteAccessMigrations); version++ {
+		tx, err := a.db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, statement := range sqliteAccessMigrations[version] {
+			if _, err = tx.Exec(statement); err != nil {
+				_ = tx.Rollback()
+				return err
+			}
+		}
+		// PRAGMA does not accept parameters
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncRoles copies roles from config to the database
+func (a *AccessManagerWithSQLite) syncRoles() error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, name := range a.roles.Names() {
+		var permissions []string
+		for _, p := range a.roles.Permissions(name) {
+			permissions = append(permissions, string(p))
+		}
+		_, err = tx.Exec(`INSERT INTO roles(name, permissions) VALUES(?, ?)
+			ON CONFLICT(name) DO UPDATE SET permissions = excluded.permissions`,
+			name, strings.Join(permissions, ","))
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// activeRole returns role of active grant in network (in app if networkId is empty) and whether there is one
+func (a *AccessManagerWithSQLite) activeRole(id int64, networkId string) (string, bool) {
+	var role string
+	err := a.db.QueryRow(`SELECT role FROM grants WHERE user_id = ? AND network_id = ? AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > ?)`,
+		id, networkId, formatSQLiteTime(time.Now())).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", false
+	}
+	if err != nil {
+		// it's safer to deny than to fail
+		return RoleBanned, true
+	}
+	return role, true
+}
+
+func (a *AccessManagerWithSQLite) GetRole(id int64) string {
+	if id == a.adminId {
+		return RoleAdmin
+	}
+	role, found := a.activeRole(id, "")
+	if !found {
+		return RoleGuest
+	}
+	return role
+}
+
+func (a *AccessManagerWithSQLite) SetRole(id int64, grant Grant) error {
+	return a.setRole(id, "", grant)
+}
+
+func (a *AccessManagerWithSQLite) GetNetworkRole(id int64, networkId string) string {
+	if id == a.adminId {
+		return RoleAdmin
+	}
+	role, found := a.activeRole(id, networkId)
+	if !found {
+		return a.GetRole(id)
+	}
+	return role
+}
+
+func (a *AccessManagerWithSQLite) SetNetworkRole(id int64, networkId string, grant Grant) error {
+	return a.setRole(id, networkId, grant)
+}
+
+// setRole revokes active grant of user in network (in app if networkId is empty) and adds a new one unless it's guest
+func (a *AccessManagerWithSQLite) setRole(id int64, networkId string, grant Grant) error {
+	if id == a.adminId {
+		return AdminMutationError
+	}
+	if !a.roles.Assignable(grant.Role) {
+		return InvalidRoleError
+	}
+	if grant.GrantedAt.IsZero() {
+		grant.GrantedAt = time.Now().UTC()
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE grants SET revoked_by = ?, revoked_at = ?
+		WHERE user_id = ? AND network_id = ? AND revoked_at IS NULL`,
+		grant.GrantedBy, formatSQLiteTime(grant.GrantedAt), id, networkId)
+	if err == nil && grant.Role != RoleGuest {
+		err = insertGrant(tx, id, networkId, grant)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertGrant(tx *sql.Tx, id int64, networkId string, grant Grant) error {
+	_, err := tx.Exec(`INSERT OR IGNORE INTO users(id, first_granted_at) VALUES(?, ?)`,
+		id, formatSQLiteTime(grant.GrantedAt))
+	if err != nil {
+		return err
+	}
+	var expiresAt sql.NullString
+	if grant.ExpiresAt != nil {
+		expiresAt = sql.NullString{String: formatSQLiteTime(*grant.ExpiresAt), Valid: true}
+	}
+	_, err = tx.Exec(`INSERT INTO grants(user_id, network_id, role, granted_by, granted_at, note, expires_at, reminded)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, networkId, grant.Role, grant.GrantedBy, formatSQLiteTime(grant.GrantedAt), grant.Note, expiresAt, grant.Reminded)
+	return err
+}
+
+func (a *AccessManagerWithSQLite) HasPermission(id int64, networkId string, permission Permission) bool {
+	globalRole := a.GetRole(id)
+	if globalRole == RoleBanned {
+		return false
+	}
+	if len(networkId) == 0 {
+		return a.roles.Has(globalRole, permission)
+	}
+	return a.roles.Has(a.GetNetworkRole(id, networkId), permission)
+}
+
+// Columns of grants table making GrantRecord, see scanGrantRecords
+const grantRecordColumns = `user_id, network_id, role, granted_by, granted_at, note, expires_at, reminded`
+
+func (a *AccessManagerWithSQLite) TemporaryGrants() ([]GrantRecord, error) {
+	rows, err := a.db.Query(`SELECT ` + grantRecordColumns + ` FROM grants
+		WHERE revoked_at IS NULL AND expires_at IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	return scanGrantRecords(rows)
+}
+
+func (a *AccessManagerWithSQLite) RemoveExpiredGrants(now time.Time) ([]GrantRecord, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.Query(`SELECT `+grantRecordColumns+` FROM grants
+		WHERE revoked_at IS NULL AND expires_at <= ?`, formatSQLiteTime(now))
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	expired, err := scanGrantRecords(rows)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE grants SET revoked_by = 0, revoked_at = expires_at
+			WHERE revoked_at IS NULL AND expires_at <= ?`, formatSQLiteTime(now))
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return expired, tx.Commit()
+}
+
+func (a *AccessManagerWithSQLite) MarkReminded(id int64, networkId string) error {
+	_, err := a.db.Exec(`UPDATE grants SET reminded = 1 WHERE user_id = ? AND network_id = ? AND revoked_at IS NULL`,
+		id, networkId)
+	return err
+}
+
+func (a *AccessManagerWithSQLite) UsersWithPermission(networkId string, permission Permission) ([]int64, error) {
+	rows, err := a.db.Query(`SELECT DISTINCT user_id FROM grants WHERE revoked_at IS NULL AND network_id IN ('', ?)`,
+		networkId)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, id)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	// roles are checked after rows are closed, since there is a single connection
+	users := []int64{a.adminId}
+	for _, id := range candidates {
+		if a.HasPermission(id, networkId, permission) {
+			users = append(users, id)
+		}
+	}
+	return users, nil
+}
+
+// scanGrantRecords reads rows of grantRecordColumns and closes them
+func scanGrantRecords(rows *sql.Rows) ([]GrantRecord, error) {
+	defer rows.Close()
+	var records []GrantRecord
+	for rows.Next() {
+		var record GrantRecord
+		var grantedAt string
+		var expiresAt sql.NullString
+		err := rows.Scan(&record.UserId, &record.NetworkId, &record.Role, &record.GrantedBy, &grantedAt, &record.Note,
+			&expiresAt, &record.Reminded)
+		if err != nil {
+			return nil, err
+		}
+		if record.GrantedAt, err = time.Parse(time.RFC3339Nano, grantedAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			t, err := time.Parse(time.RFC3339Nano, expiresAt.String)
+			if err != nil {
+				return nil, err
+			}
+			record.ExpiresAt = &t
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// ImportOpsFile copies grants from ops file of AccessManagerWithFileStorage (any version) to the database.
+// Nothing is imported if some user already has a role in the database. Grants of admin are skipped.
+func (a *AccessManagerWithSQLite) ImportOpsFile(filepath string) (int, error) {
+	fileData, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return 0, err
+	}
+	data, _, err := decodeAccessFile(fileData)
+	if err != nil {
+		return 0, fmt.Errorf("ops file %s: %w", filepath, err)
+	}
+
+	grants := make(map[string]map[int64]Grant, len(data.Networks)+1)
+	grants[""] = data.Users
+	for networkId, users := range data.Networks {
+		grants[networkId] = users
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var found int
+	err = tx.QueryRow(`SELECT 1 FROM grants WHERE revoked_at IS NULL LIMIT 1`).Scan(&found)
+	if err != sql.ErrNoRows {
+		_ = tx.Rollback()
+		if err == nil {
+			err = errors.New("users already have roles in the database, nothing is imported")
+		}
+		return 0, err
+	}
+	imported := 0
+	for networkId, users := range grants {
+		for id, grant := range users {
+			if id == a.adminId {
+				log.Printf("Ops file %s: skipping role %q of admin %d", filepath, grant.Role, id)
+				continue
+			}
+			if !a.roles.Assignable(grant.Role) || grant.Role == RoleGuest {
+				_ = tx.Rollback()
+				return 0, fmt.Errorf("ops file %s: user %d has invalid role %q", filepath, id, grant.Role)
+			}
+			if err = insertGrant(tx, id, networkId, grant); err != nil {
+				_ = tx.Rollback()
+				return 0, fmt.Errorf("importing role of user %d: %w", id, err)
+			}
+			imported++
+		}
+	}
+	return imported, tx.Commit()
+}
+
+func (a *AccessManagerWithSQLite) Close() error {
+	return a.db.Close()
+}
+
+// formatSQLiteTime formats t so that times can be compared as strings
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z07:00")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSQLite opens dbFile with built-in roles, it is closed on cleanup
+func newTestSQLite(t *testing.T, dbFile string) *AccessManagerWithSQLite {
+	t.Helper()
+	roles, err := NewRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessManager, err := NewAccessManagerWithSQLite(testAdminId, roles, dbFile)
+	if err != nil {
+		t.Fatalf("NewAccessManagerWithSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { _ = accessManager.Close() })
+	return accessManager
+}
+
+func TestSQLiteRolesSurviveReopen(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "ops.db")
+	first := newTestSQLite(t, dbFile)
+	if err := first.SetRole(2, Grant{Role: RoleOperator, GrantedBy: testAdminId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.SetNetworkRole(3, testNetworkId, Grant{Role: RoleBanned, GrantedBy: testAdminId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestSQLite(t, dbFile)
+	if role := second.GetRole(2); role != RoleOperator {
+		t.Errorf("role of 2 = %q, want %q", role, RoleOperator)
+	}
+	if role := second.GetNetworkRole(3, testNetworkId); role != RoleBanned {
+		t.Errorf("role of 3 in network = %q, want %q", role, RoleBanned)
+	}
+	if role := second.GetNetworkRole(3, testOtherNetworkId); role != RoleGuest {
+		t.Errorf("role of 3 in other network = %q, want %q", role, RoleGuest)
+	}
+}
+
+func TestSQLiteSetRoleRevokesPreviousGrant(t *testing.T) {
+	accessManager := newTestSQLite(t, filepath.Join(t.TempDir(), "ops.db"))
+	if err := accessManager.SetRole(2, Grant{Role: RoleOperator, GrantedBy: testAdminId}); err != nil {
+		t.Fatal(err)
+	}
+	if err := accessManager.SetRole(2, Grant{Role: RoleBanned, GrantedBy: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := accessManager.SetRole(2, Grant{Role: RoleGuest, GrantedBy: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := accessManager.db.Query(`SELECT role, revoked_by FROM grants WHERE user_id = 2 ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	type historyRow struct {
+		role      string
+		revokedBy int64
+	}
+	var history []historyRow
+	for rows.Next() {
+		var row historyRow
+		if err = rows.Scan(&row.role, &row.revokedBy); err != nil {
+			t.Fatal(err)
+		}
+		history = append(history, row)
+	}
+	want := []historyRow{{RoleOperator, 5}, {RoleBanned, 6}}
+	if len(history) != len(want) || history[0] != want[0] || history[1] != want[1] {
+		t.Errorf("history = %v, want %v", history, want)
+	}
+	if role := accessManager.GetRole(2); role != RoleGuest {
+		t.Errorf("role of 2 = %q, want %q", role, RoleGuest)
+	}
+}
+
+func TestSQLiteExpiredNetworkGrant(t *testing.T) {
+	accessManager := newTestSQLite(t, filepath.Join(t.TempDir(), "ops.db"))
+	expiresAt := time.Now().Add(-time.Minute)
+	err := accessManager.SetNetworkRole(2, testNetworkId, Grant{Role: RoleOperator, GrantedBy: testAdminId, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if role := accessManager.GetNetworkRole(2, testNetworkId); role != RoleGuest {
+		t.Errorf("role of 2 with expired grant = %q, want %q", role, RoleGuest)
+	}
+	expired, err := accessManager.RemoveExpiredGrants(time.Now())
+	if err != nil {
+		t.Fatalf("RemoveExpiredGrants() error = %v", err)
+	}
+	if len(expired) != 1 || expired[0].UserId != 2 || expired[0].NetworkId != testNetworkId || expired[0].Role != RoleOperator {
+		t.Errorf("RemoveExpiredGrants() = %+v, want operator grant of 2 in network", expired)
+	}
+	if expired, err = accessManager.RemoveExpiredGrants(time.Now()); err != nil || len(expired) != 0 {
+		t.Errorf("second RemoveExpiredGrants() = %+v, %v, want nothing", expired, err)
+	}
+}
+
+func TestSQLiteImportOpsFile(t *testing.T) {
+	dir := t.TempDir()
+	opsFile := filepath.Join(dir, "ops.txt")
+	data := `{"version": 3, "users": {"1": {"role": "banned"}, "2": {"role": "operator"}},
+		"networks": {"` + testNetworkId + `": {"3": {"role": "operator"}}}}`
+	if err := ioutil.WriteFile(opsFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	accessManager := newTestSQLite(t, filepath.Join(dir, "ops.db"))
+
+	imported, err := accessManager.ImportOpsFile(opsFile)
+	if err != nil {
+		t.Fatalf("ImportOpsFile() error = %v", err)
+	}
+	// role of admin is skipped
+	if imported != 2 {
+		t.Errorf("ImportOpsFile() imported %d grants, want 2", imported)
+	}
+	if role := accessManager.GetRole(testAdminId); role != RoleAdmin {
+		t.Errorf("role of admin = %q, want %q", role, RoleAdmin)
+	}
+	if role := accessManager.GetRole(2); role != RoleOperator {
+		t.Errorf("role of 2 = %q, want %q", role, RoleOperator)
+	}
+	if role := accessManager.GetNetworkRole(3, testNetworkId); role != RoleOperator {
+		t.Errorf("role of 3 in network = %q, want %q", role, RoleOperator)
+	}
+
+	imported, err = accessManager.ImportOpsFile(opsFile)
+	if err == nil || !strings.Contains(err.Error(), "already have roles") || imported != 0 {
+		t.Errorf("second ImportOpsFile() = %d, %v, want it refused", imported, err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Admin might have had a role before admin_id was changed, it must not stop the bot from starting
+func TestFileStorageDropsAdminGrants(t *testing.T) {
+	roles, err := NewRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsFile := filepath.Join(t.TempDir(), "ops.txt")
+	data := `{"version": 3, "users": {"1": {"role": "operator"}, "2": {"role": "operator"}},
+		"networks": {"` + testNetworkId + `": {"1": {"role": "banned"}}}}`
+	if err = ioutil.WriteFile(opsFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	accessManager, err := NewAccessManagerWithFileStorage(1, roles, opsFile)
+	if err != nil {
+		t.Fatalf("NewAccessManagerWithFileStorage() error = %v", err)
+	}
+	defer accessManager.Close()
+	if role := accessManager.GetNetworkRole(1, testNetworkId); role != RoleAdmin {
+		t.Errorf("role of admin = %q, want %q", role, RoleAdmin)
+	}
+	if role := accessManager.GetRole(2); role != RoleOperator {
+		t.Errorf("role of 2 = %q, want %q", role, RoleOperator)
+	}
+}
+
+// Ops must survive restart of the bot
+func TestFileStorageGrantsAreLoadedOnRestart(t *testing.T) {
+	roles, err := NewRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsFile := filepath.Join(t.TempDir(), "ops.txt")
+	first, err := NewAccessManagerWithFileStorage(testAdminId, roles, opsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = first.SetRole(2, Grant{Role: RoleOperator, GrantedBy: testAdminId}); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.SetNetworkRole(3, testNetworkId, Grant{Role: RoleOperator, GrantedBy: testAdminId}); err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := NewAccessManagerWithFileStorage(testAdminId, roles, opsFile)
+	if err != nil {
+		t.Fatalf("NewAccessManagerWithFileStorage() error = %v", err)
+	}
+	defer second.Close()
+	if role := second.GetRole(2); role != RoleOperator {
+		t.Errorf("role of 2 = %q, want %q", role, RoleOperator)
+	}
+	if role := second.GetNetworkRole(3, testNetworkId); role != RoleOperator {
+		t.Errorf("role of 3 in network = %q, want %q", role, RoleOperator)
+	}
+	if role := second.GetRole(3); role != RoleGuest {
+		t.Errorf("role of 3 in app = %q, want %q", role, RoleGuest)
+	}
+}
+
+func TestFileStorageMigratesVersion0(t *testing.T) {
+	roles, err := NewRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsFile := filepath.Join(t.TempDir(), "ops.txt")
+	const legacy = `{"5":2}`
+	if err = ioutil.WriteFile(opsFile, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	accessManager, err := NewAccessManagerWithFileStorage(testAdminId, roles, opsFile)
+	if err != nil {
+		t.Fatalf("NewAccessManagerWithFileStorage() error = %v", err)
+	}
+	defer accessManager.Close()
+	if role := accessManager.GetRole(5); role != RoleOperator {
+		t.Errorf("role of 5 = %q, want %q", role, RoleOperator)
+	}
+
+	backup, err := ioutil.ReadFile(opsFile + ".v0.bak")
+	if err != nil {
+		t.Fatalf("backup of version 0: %v", err)
+	}
+	if string(backup) != legacy {
+		t.Errorf("backup = %s, want %s", backup, legacy)
+	}
+	fileData, err := ioutil.ReadFile(opsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data accessFileData
+	if err = json.Unmarshal(fileData, &data); err != nil {
+		t.Fatalf("migrated file: %v", err)
+	}
+	if data.Version != accessFileVersion || data.Users[5].Role != RoleOperator {
+		t.Errorf("migrated file = %s, want version %d with 5 as operator", fileData, accessFileVersion)
+	}
+}
+
+// Two bots sharing ops file would overwrite each other's changes
+func TestFileStorageIsLocked(t *testing.T) {
+	roles, err := NewRoles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsFile := filepath.Join(t.TempDir(), "ops.txt")
+	first, err := NewAccessManagerWithFileStorage(testAdminId, roles, opsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewAccessManagerWithFileStorage(testAdminId, roles, opsFile); !errors.Is(err, FileLockedError) {
+		t.Errorf("second NewAccessManagerWithFileStorage() error = %v, want %v", err, FileLockedError)
+	}
+	if err = first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewAccessManagerWithFileStorage(testAdminId, roles, opsFile)
+	if err != nil {
+		t.Fatalf("NewAccessManagerWithFileStorage() after Close error = %v", err)
+	}
+	_ = second.Close()
+}
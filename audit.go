@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry is a record of one privileged action
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	ActorId   int64     `json:"actor_id"`
+	ActorName string    `json:"actor_name,omitempty"` // telegram username
+	Command   string    `json:"command"`
+	Args      string    `json:"args,omitempty"`
+	Network   string    `json:"network,omitempty"`
+	Target    string    `json:"target,omitempty"` // NodeID, user id or setting name depending on command
+	Result    string    `json:"result"`
+}
+
+// Matches tells if entry has given actor id, actor username or target
+func (e *AuditEntry) Matches(filter string) bool {
+	return filter == strconv.FormatInt(e.ActorId, 10) ||
+		filter == e.Target ||
+		(len(e.ActorName) > 0 && strings.TrimPrefix(filter, "@") == e.ActorName)
+}
+
+// AuditLog is append-only log of privileged actions
+type AuditLog interface {
+	Record(entry AuditEntry) error
+	// Last returns up to n latest entries for which match returns true, all if match is nil.
+	// Entries are sorted from the oldest to the newest.
+	Last(n int, match func(entry *AuditEntry) bool) ([]AuditEntry, error)
+}
+
+// AuditLogFile keeps audit log in JSON lines file, one entry per line. It is safe for concurrent use.
+type AuditLogFile struct {
+	mu       sync.Mutex
+	filepath string
+	file     *os.File
+}
+
+func NewAuditLogFile(filepath string) (*AuditLogFile, error) {
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLogFile{filepath: filepath, file: f}, nil
+}
+
+func (l *AuditLogFile) Record(entry AuditEntry) error {
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err = l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+func (l *AuditLogFile) Last(n int, match func(entry *AuditEntry) bool) ([]AuditEntry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.Open(l.filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// keeps last n matching entries
+	entries := make([]AuditEntry, 0, n)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a line might have been cut off by a crash
+			continue
+		}
+		if match != nil && !match(&entry) {
+			continue
+		}
+		if len(entries) == n {
+			copy(entries, entries[1:])
+			entries[n-1] = entry
+		} else {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func (l *AuditLogFile) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// telegram limits callback data to 64 bytes
+	maxCallbackDataLength = 64
+	// bytes of HMAC kept in callback data
+	callbackSignatureLength = 8
+	callbackSeparator       = ":"
+)
+
+var (
+	CallbackDataTooLong = errors.New("callback data is too long")
+	InvalidCallbackData = errors.New("invalid callback data")
+	CallbackExpired     = errors.New("callback has expired")
+)
+
+// CallbackHandler handles presses of inline keyboard buttons made by CallbackSigner.Button with its route.
+// It must pass given context to every ZeroTierApi call.
+// HandleCallback is called only if user meets the requirement returned by CallbackRequires, network-scoped
+// callbacks have network id as first argument.
+type CallbackHandler interface {
+	HandleCallback(context.Context, *tgbotapi.CallbackQuery, []string, ZeroTierApi, AccessManager) (CallbackReply, error)
+	CallbackRequires() AccessRequirement
+}
+
+// AuditedCallbackHandler is a CallbackHandler that changes something, see AuditedCommandHandler.
+// CallbackAuditTarget is called before the callback is handled.
+type AuditedCallbackHandler interface {
+	CallbackHandler
+	CallbackAuditTarget(args []string) (networkId string, target string, audited bool)
+}
+
+// CallbackReply is an answer to press of inline keyboard button: Text is shown to user as a notification,
+// message with the button is replaced with EditText (without buttons) unless it's empty
+type CallbackReply struct {
+	Text     string
+	EditText string
+}
+
+// CallbackSigner makes callback data of buttons in form `route:arg...:expiry:signature`.
+// Data is signed along with id of user the button is sent to, so that the bot accepts only data it has made itself,
+// for that user and until it expires.
+type CallbackSigner struct {
+	key []byte
+}
+
+// NewCallbackSigner makes signer with given secret, or random one if it is empty.
+// Buttons signed with random secret are invalid after restart.
+func NewCallbackSigner(secret string) (*CallbackSigner, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &CallbackSigner{key: key}, nil
+}
+
+// Button makes inline keyboard button for user, valid for lifetime. Route and args must not contain `:`.
+func (s *CallbackSigner) Button(text string, userId int64, lifetime time.Duration, route string, args ...string) (tgbotapi.InlineKeyboardButton, error) {
+	for _, arg := range append([]string{route}, args...) {
+		if len(arg) == 0 || strings.Contains(arg, callbackSeparator) {
+			return tgbotapi.InlineKeyboardButton{}, fmt.Errorf("%w: %q", InvalidCallbackData, arg)
+		}
+	}
+	payload := strings.Join(append([]string{route}, args...), callbackSeparator) + callbackSeparator +
+		strconv.FormatInt(time.Now().Add(lifetime).Unix(), 36)
+	data := payload + callbackSeparator + s.sign(userId, payload)
+	if len(data) > maxCallbackDataLength {
+		return tgbotapi.InlineKeyboardButton{}, fmt.Errorf("%w: %s", CallbackDataTooLong, data)
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data), nil
+}
+
+// Verify checks data of button pressed by user and returns its route and args
+func (s *CallbackSigner) Verify(data string, userId int64, now time.Time) (string, []string, error) {
+	i := strings.LastIndex(data, callbackSeparator)
+	if i < 0 {
+		return "", nil, InvalidCallbackData
+	}
+	payload, signature := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.sign(userId, payload))) {
+		return "", nil, InvalidCallbackData
+	}
+	parts := strings.Split(payload, callbackSeparator)
+	if len(parts) < 2 {
+		return "", nil, InvalidCallbackData
+	}
+	expiresAt, err := strconv.ParseInt(parts[len(parts)-1], 36, 64)
+	if err != nil {
+		return "", nil, InvalidCallbackData
+	}
+	if now.Unix() > expiresAt {
+		return "", nil, CallbackExpired
+	}
+	return parts[0], parts[1 : len(parts)-1], nil
+}
+
+func (s *CallbackSigner) sign(userId int64, payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strconv.FormatInt(userId, 10) + callbackSeparator + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSignatureLength])
+}
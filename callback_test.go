@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackSignerVerify(t *testing.T) {
+	const userId = 2
+	now := time.Now()
+	signer, err := NewCallbackSigner("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCallbackSigner("other secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	button, err := signer.Button("Approve", userId, time.Hour, "join", testNetworkId, "approve", "0a1b2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := *button.CallbackData
+	// signed makes data for payload as Button does, so that only the tested part is invalid
+	signed := func(s *CallbackSigner, userId int64, payload string) string {
+		return payload + callbackSeparator + s.sign(userId, payload)
+	}
+	expiry := strconv.FormatInt(now.Add(time.Hour).Unix(), 36)
+	tampered := data[:len(data)-1] + "A"
+	if tampered == data {
+		tampered = data[:len(data)-1] + "B"
+	}
+
+	route, args, err := signer.Verify(data, userId, now)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if route != "join" || len(args) != 3 || args[0] != testNetworkId || args[1] != "approve" || args[2] != "0a1b2c" {
+		t.Errorf("Verify() = %q, %q, want join route with its args", route, args)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		userId int64
+		now    time.Time
+		want   error
+	}{
+		{name: "tampered signature", data: tampered, want: InvalidCallbackData},
+		{name: "truncated signature", data: data[:len(data)-2], want: InvalidCallbackData},
+		{name: "tampered args", data: strings.Replace(data, ":approve:", ":deny:", 1), want: InvalidCallbackData},
+		{name: "other user", data: data, userId: 3, want: InvalidCallbackData},
+		{name: "expired", data: data, now: now.Add(2 * time.Hour), want: CallbackExpired},
+		{name: "other key", data: signed(other, userId, "join:"+testNetworkId+":approve:0a1b2c:"+expiry), want: InvalidCallbackData},
+		{name: "no signature", data: "join", want: InvalidCallbackData},
+		{name: "no expiry", data: signed(signer, userId, "join"), want: InvalidCallbackData},
+		{name: "empty expiry", data: signed(signer, userId, "join:"), want: InvalidCallbackData},
+		{name: "non-base36 expiry", data: signed(signer, userId, "join:"+testNetworkId+":z!"), want: InvalidCallbackData},
+		{name: "too long expiry", data: signed(signer, userId, "join:"+testNetworkId+":zzzzzzzzzzzzzzzz"), want: InvalidCallbackData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.userId == 0 {
+				tt.userId = userId
+			}
+			if tt.now.IsZero() {
+				tt.now = now
+			}
+			route, args, err := signer.Verify(tt.data, tt.userId, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify(%q) = %q, %q, %v, want error %v", tt.data, route, args, err, tt.want)
+			}
+		})
+	}
+}
+
+// probeCallback is a network-scoped button handler requiring members.auth, it counts its calls
+type probeCallback struct {
+	calls *int
+}
+
+func (h probeCallback) HandleCallback(context.Context, *tgbotapi.CallbackQuery, []string, ZeroTierApi, AccessManager) (CallbackReply, error) {
+	*h.calls++
+	return CallbackReply{Text: "Done."}, nil
+}
+
+func (probeCallback) CallbackRequires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersAuth, NetworkScoped: true}
+}
+
+// Valid signature proves only that the bot has made the button for the user, not that the user may press it now
+func TestHandleCallbackChecksAccess(t *testing.T) {
+	cm := newTestCommandManager(t, newTestZTApi(t, NewFakeCentral(testToken)))
+	calls := 0
+	cm.registeredCallbacks["probe"] = probeCallback{&calls}
+	handle(t, cm, testAdminId, "/op 3 prod")
+	handle(t, cm, testAdminId, "/op 4 lab")
+
+	tests := []struct {
+		name    string
+		userId  int64
+		want    string
+		handled bool
+	}{
+		{name: "guest", userId: 2, want: AccessDeniedText},
+		{name: "operator of other network", userId: 3, want: AccessDeniedText},
+		{name: "operator of the network", userId: 4, want: "Done.", handled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			button, err := cm.signer.Button("Press", tt.userId, time.Hour, "probe", testNetworkId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: int(tt.userId)}, Data: *button.CallbackData}
+			rep, err := cm.HandleCallback(context.Background(), query)
+			if err != nil {
+				t.Fatalf("HandleCallback() error = %v", err)
+			}
+			if rep.Text != tt.want {
+				t.Errorf("HandleCallback() text = %q, want %q", rep.Text, tt.want)
+			}
+			if handled := calls == 1; handled != tt.handled || calls > 1 {
+				t.Errorf("handler called %d times, want handled: %v", calls, tt.handled)
+			}
+		})
+	}
+}
@@ -1,78 +1,398 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const AccessDeniedText = "Access denied. If you think that's a mistake, contact you administrator."
+const ZeroTierTimeoutText = "ZeroTier did not respond. Try again later."
 
+// CommandHandler must pass given context to every ZeroTierApi call.
+// Handle is called only if user meets the requirement returned by Requires.
 type CommandHandler interface {
-	Handle(*tgbotapi.Message, *ZeroTierApi, AccessManager) (tgbotapi.MessageConfig, error)
+	Handle(context.Context, *tgbotapi.Message, ZeroTierApi, AccessManager) (tgbotapi.MessageConfig, error)
 	Description() string
+	Requires() AccessRequirement
 }
 
+// AuditedCommandHandler is a CommandHandler that changes something. Its uses are recorded in audit log,
+// including denied ones. AuditTarget tells network (if any) and target (NodeID, user id etc.) of the command in msg,
+// audited is false if the command changes nothing this time, e.g. is only asked to show something.
+type AuditedCommandHandler interface {
+	CommandHandler
+	AuditTarget(msg *tgbotapi.Message) (networkId string, target string, audited bool)
+}
+
+// AccessRequirement is what user needs to run a command
+type AccessRequirement struct {
+	// Empty for commands available to everyone except banned users
+	Permission Permission
+	// Network-scoped commands act on network from optional `@network` first argument (see Networks.FromArgs),
+	// the permission is checked in that network. Other commands need the permission in app or in any network,
+	// they have to check arguments-dependent scope themselves.
+	NetworkScoped bool
+}
+
+// MessageSender sends message on behalf of the bot, e.g. to users other than one whose command is handled
+type MessageSender func(msg tgbotapi.MessageConfig) error
+
 type CommandManager struct {
-	registeredCommands map[string]CommandHandler
-	ztApi              *ZeroTierApi
-	accessManager      AccessManager
+	registeredCommands  map[string]CommandHandler
+	registeredCallbacks map[string]CallbackHandler
+	signer              *CallbackSigner
+	ztApi               ZeroTierApi
+	accessManager       AccessManager
+	networks            *Networks
+	auditLog            AuditLog
+	middlewares         []Middleware
+	callbackMiddlewares []CallbackMiddleware
 }
 
 // Allocates new CommandManager with hardcoded registered commands
 // If use want to implement new command you have create a handler type that implements CommandHandler interface
 // and register it in this function the same way it done for already existing commands.
 // I recommend to place the handler type in a separate file (look at `handlers_*.go` for example).
-func NewCommandManager(ztApi *ZeroTierApi, accessManager AccessManager) *CommandManager {
+// Handlers of buttons (see CallbackHandler) are registered the same way by route of their callback data.
+// auditLog, expiry and joinRequests may be nil if audit, time-limited authorization and join requests are disabled.
+func NewCommandManager(ztApi ZeroTierApi, accessManager AccessManager, networks *Networks, roles *Roles,
+	auditLog AuditLog, expiry *ExpiryScheduler, joinRequests *JoinRequests, send MessageSender, signer *CallbackSigner) *CommandManager {
 	cm := &CommandManager{
-		registeredCommands: make(map[string]CommandHandler),
-		ztApi:              ztApi,
-		accessManager:      accessManager,
+		registeredCommands:  make(map[string]CommandHandler),
+		registeredCallbacks: make(map[string]CallbackHandler),
+		signer:              signer,
+		ztApi:               ztApi,
+		accessManager:       accessManager,
+		networks:            networks,
+		auditLog:            auditLog,
 	}
 	cm.registeredCommands["start"] = StartHandler{}
-	cm.registeredCommands["auth"] = AuthHandler{}
-	cm.registeredCommands["unauth"] = UnauthHandler{}
-	cm.registeredCommands["list"] = ListMembersHandler{}
-	cm.registeredCommands["op"] = OpHandler{}
-	cm.registeredCommands["deop"] = DeopHandler{}
+	cm.registeredCommands["help"] = HelpHandler{cm}
+	cm.registeredCommands["auth"] = AuthHandler{networks, expiry}
+	cm.registeredCommands["unauth"] = UnauthHandler{networks, expiry}
+	remove := RemoveHandler{networks, expiry, signer}
+	cm.registeredCommands["remove"] = remove
+	cm.registeredCallbacks[removeCallbackRoute] = remove
+	cm.registeredCommands["list"] = ListMembersHandler{networks}
+	cm.registeredCommands["network"] = NetworkHandler{networks}
+	cm.registeredCommands["use"] = UseHandler{networks}
+	cm.registeredCommands["op"] = OpHandler{networks}
+	cm.registeredCommands["deop"] = DeopHandler{networks}
+	cm.registeredCommands["role"] = RoleHandler{networks, roles}
+	if auditLog != nil {
+		cm.registeredCommands["audit"] = AuditHandler{networks, auditLog}
+	}
+	if joinRequests != nil {
+		join := JoinHandler{networks, joinRequests, send, signer, expiry}
+		cm.registeredCommands["join"] = join
+		cm.registeredCallbacks[joinCallbackRoute] = join
+	}
 
 	return cm
 }
 
-func (cm *CommandManager) HandleMessage(msg *tgbotapi.Message) (tgbotapi.MessageConfig, error) {
+// Use adds middlewares wrapping every command, the first of them being the outermost.
+// It must be called before messages are handled.
+func (cm *CommandManager) Use(middlewares ...Middleware) {
+	cm.middlewares = append(cm.middlewares, middlewares...)
+}
+
+// UseCallbacks adds middlewares wrapping every press of a button, the first of them being the outermost.
+// It must be called before callbacks are handled.
+func (cm *CommandManager) UseCallbacks(middlewares ...CallbackMiddleware) {
+	cm.callbackMiddlewares = append(cm.callbackMiddlewares, middlewares...)
+}
+
+// HandleMessage runs handler of the command given in msg through the middlewares.
+// ctx should be cancelled on shutdown; if it is done or ZeroTier times out, user is told that ZeroTier did not respond.
+func (cm *CommandManager) HandleMessage(ctx context.Context, msg *tgbotapi.Message) (tgbotapi.MessageConfig, error) {
+	return chain(cm.dispatch, cm.middlewares...)(ctx, msg)
+}
+
+// dispatch checks access and runs handler of the command
+func (cm *CommandManager) dispatch(ctx context.Context, msg *tgbotapi.Message) (tgbotapi.MessageConfig, error) {
 	if len(msg.Command()) == 0 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "I understand commands only. Try /help."), nil
 	}
-	if cm.accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelGuest {
+	if cm.accessManager.GetRole(msg.Chat.ID) == RoleBanned {
 		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
 	}
-	// help needs to be handled in special way
-	if msg.Command() == "help" {
-		if cm.accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelOperator {
-			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-		}
-		return tgbotapi.NewMessage(msg.Chat.ID, cm.HelpText()), nil
-	}
 	handler, found := cm.registeredCommands[msg.Command()]
 	if !found {
 		return tgbotapi.NewMessage(msg.Chat.ID, "Unknown command. Try /help."), nil
 	}
-	return handler.Handle(msg, cm.ztApi, cm.accessManager)
+	if denial, ok := cm.checkAccess(msg, handler.Requires()); !ok {
+		cm.audit(msg, handler, "denied: "+denial)
+		return tgbotapi.NewMessage(msg.Chat.ID, denial), nil
+	}
+	rep, err := handler.Handle(ctx, msg, cm.ztApi, cm.accessManager)
+	if err != nil {
+		if text, ok := explainZeroTierError(err); ok {
+			log.Println(err)
+			rep, err = tgbotapi.NewMessage(msg.Chat.ID, text), nil
+		}
+	}
+	if err != nil {
+		cm.audit(msg, handler, "error: "+err.Error())
+	} else {
+		cm.audit(msg, handler, rep.Text)
+	}
+	return rep, err
+}
+
+// audit records use of the command if its handler is an AuditedCommandHandler
+func (cm *CommandManager) audit(msg *tgbotapi.Message, handler CommandHandler, result string) {
+	audited, ok := handler.(AuditedCommandHandler)
+	if !ok {
+		return
+	}
+	networkId, target, ok := audited.AuditTarget(msg)
+	if !ok {
+		return
+	}
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		ActorId: msg.Chat.ID,
+		Command: msg.Command(),
+		Args:    msg.CommandArguments(),
+		Network: networkId,
+		Target:  target,
+		Result:  result,
+	}
+	if msg.From != nil {
+		entry.ActorName = msg.From.UserName
+	}
+	cm.record(entry)
 }
 
-func (cm *CommandManager) HelpText() string {
+// record adds entry to audit log if it is enabled, failures are logged
+func (cm *CommandManager) record(entry AuditEntry) {
+	if cm.auditLog == nil {
+		return
+	}
+	if err := cm.auditLog.Record(entry); err != nil {
+		log.Printf("audit log: %s", err.Error())
+	}
+}
+
+// HandleCallback runs handler of the button's route through the callback middlewares
+func (cm *CommandManager) HandleCallback(ctx context.Context, query *tgbotapi.CallbackQuery) (CallbackReply, error) {
+	return chainCallbacks(cm.dispatchCallback, cm.callbackMiddlewares...)(ctx, query)
+}
+
+// dispatchCallback checks signature of callback data and access, and runs handler of the button's route
+func (cm *CommandManager) dispatchCallback(ctx context.Context, query *tgbotapi.CallbackQuery) (CallbackReply, error) {
+	userId := int64(query.From.ID)
+	if cm.accessManager.GetRole(userId) == RoleBanned {
+		return CallbackReply{Text: AccessDeniedText}, nil
+	}
+	route, args, err := cm.signer.Verify(query.Data, userId, time.Now())
+	if err == CallbackExpired {
+		return CallbackReply{Text: "The button has expired."}, nil
+	}
+	if err != nil {
+		log.Printf("callback from %d: %s: %q", userId, err.Error(), query.Data)
+		return CallbackReply{Text: "Invalid button."}, nil
+	}
+	handler, found := cm.registeredCallbacks[route]
+	if !found {
+		return CallbackReply{Text: "Unknown button."}, nil
+	}
+
+	// records result if the handler is an AuditedCallbackHandler; target is taken before the callback changes it
+	audit := func(result string) {}
+	if audited, ok := handler.(AuditedCallbackHandler); ok {
+		if networkId, target, ok := audited.CallbackAuditTarget(args); ok {
+			audit = func(result string) {
+				cm.record(AuditEntry{
+					Time:      time.Now().UTC(),
+					ActorId:   userId,
+					ActorName: query.From.UserName,
+					Command:   route,
+					Args:      strings.Join(args, " "),
+					Network:   networkId,
+					Target:    target,
+					Result:    result,
+				})
+			}
+		}
+	}
+	requirement := handler.CallbackRequires()
+	networkId := ""
+	if requirement.NetworkScoped {
+		if len(args) == 0 {
+			return CallbackReply{Text: "Invalid button."}, nil
+		}
+		if networkId, err = cm.networks.Resolve(args[0]); err != nil {
+			return CallbackReply{Text: networkErrorText(err)}, nil
+		}
+	}
+	if !cm.meetsRequirement(userId, networkId, requirement) {
+		audit("denied: " + AccessDeniedText)
+		return CallbackReply{Text: AccessDeniedText}, nil
+	}
+
+	rep, err := handler.HandleCallback(ctx, query, args, cm.ztApi, cm.accessManager)
+	if err != nil {
+		if text, ok := explainZeroTierError(err); ok {
+			log.Println(err)
+			rep, err = CallbackReply{Text: text}, nil
+		}
+	}
+	if err != nil {
+		audit("error: " + err.Error())
+	} else {
+		audit(rep.Text)
+	}
+	return rep, err
+}
+
+// explainZeroTierError makes a user-facing message for ZeroTierApi errors that are not bot's fault.
+// Handlers are expected to explain errors depending on command themselves (e.g. IsNotFound).
+func explainZeroTierError(err error) (string, bool) {
+	if IsUnauthorized(err) {
+		return "The bot's ZeroTier token is invalid or has no access to the network. Contact your administrator.", true
+	}
+	var unavailable *ZTUnavailableError
+	if errors.As(err, &unavailable) {
+		if unavailable.IsRateLimited() {
+			text := "ZeroTier rate limit exceeded, the request has been rejected."
+			if unavailable.RetryAfter > 0 {
+				text += fmt.Sprintf(" Try again in %s.", unavailable.RetryAfter.Round(time.Second))
+			} else {
+				text += " Try again later."
+			}
+			return text, true
+		}
+		if unavailable.StatusCode != 0 {
+			return fmt.Sprintf("ZeroTier is unavailable (status %d after %d attempt(s)). Try again later.",
+				unavailable.StatusCode, unavailable.Attempts), true
+		}
+		return fmt.Sprintf("Cannot reach ZeroTier after %d attempt(s). Try again later.", unavailable.Attempts), true
+	}
+	if IsRateLimited(err) {
+		return "ZeroTier rate limit exceeded, the request has been rejected. Try again later.", true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode >= 500 {
+			return fmt.Sprintf("ZeroTier is unavailable (status %d). Try again later.", apiErr.StatusCode), true
+		}
+		if len(apiErr.Message) > 0 {
+			return fmt.Sprintf("ZeroTier rejected the request (status %d): %s", apiErr.StatusCode, apiErr.Message), true
+		}
+		return fmt.Sprintf("ZeroTier rejected the request (status %d).", apiErr.StatusCode), true
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ZeroTierTimeoutText, true
+	}
+	return "", false
+}
+
+// checkAccess tells if user given in msg meets the requirement, and if not, what to reply
+func (cm *CommandManager) checkAccess(msg *tgbotapi.Message, requirement AccessRequirement) (string, bool) {
+	if len(requirement.Permission) == 0 {
+		return "", true
+	}
+	networkId := ""
+	if requirement.NetworkScoped {
+		var err error
+		networkId, _, err = cm.networks.FromArgs(msg.Chat.ID, splitArgs(msg.CommandArguments()))
+		if err != nil {
+			return networkErrorText(err), false
+		}
+	}
+	return AccessDeniedText, cm.meetsRequirement(msg.Chat.ID, networkId, requirement)
+}
+
+// meetsRequirement tells if user has permission of the requirement in network if it's network-scoped,
+// otherwise in app or in any network
+func (cm *CommandManager) meetsRequirement(userId int64, networkId string, requirement AccessRequirement) bool {
+	if len(requirement.Permission) == 0 {
+		return true
+	}
+	if requirement.NetworkScoped {
+		return cm.accessManager.HasPermission(userId, networkId, requirement.Permission)
+	}
+	return hasPermissionInAnyNetwork(cm.accessManager, cm.networks, userId, requirement.Permission)
+}
+
+// HelpText lists commands which user with given id can use
+func (cm *CommandManager) HelpText(id int64) string {
 	txt := "Help:\n" +
-		"This bot is used to manage a ZeroTier network via ZeroTier-Central API.\n" +
-		"Available commands:\n" +
-		"/help : provides help.\n"
+		"This bot is used to manage a ZeroTier network via ZeroTier Central or controller API.\n" +
+		"Available commands:\n"
+	commands := make([]string, 0, len(cm.registeredCommands))
 	for k, v := range cm.registeredCommands {
-		txt += "/" + k + " : " + v.Description() + "\n"
+		permission := v.Requires().Permission
+		if len(permission) == 0 || hasPermissionInAnyNetwork(cm.accessManager, cm.networks, id, permission) {
+			commands = append(commands, k)
+		}
+	}
+	sort.Strings(commands)
+	for _, k := range commands {
+		txt += "/" + k + " : " + cm.registeredCommands[k].Description() + "\n"
 	}
 	return txt
 }
 
+// hasPermissionInAnyNetwork tells if user has permission globally or in some of managed networks
+func hasPermissionInAnyNetwork(accessManager AccessManager, networks *Networks, id int64, permission Permission) bool {
+	if accessManager.HasPermission(id, "", permission) {
+		return true
+	}
+	for _, networkId := range networks.List() {
+		if accessManager.HasPermission(id, networkId, permission) {
+			return true
+		}
+	}
+	return false
+}
+
 func splitArgs(args string) []string {
 	if len(args) == 0 {
 		return nil
 	}
 	return strings.Split(args, " ")
 }
+
+// firstArg returns first of args or empty string if there are none
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// popDurationFlag removes `name duration` from args. Duration is 0 if there is no such flag.
+// Besides time.ParseDuration format, whole days like `3d` are accepted.
+func popDurationFlag(args []string, name string) (time.Duration, []string, error) {
+	for i, arg := range args {
+		if arg != name {
+			continue
+		}
+		if i+1 == len(args) {
+			return 0, args, fmt.Errorf("%s needs a duration", name)
+		}
+		value := args[i+1]
+		var d time.Duration
+		var err error
+		if days, convErr := strconv.Atoi(strings.TrimSuffix(value, "d")); convErr == nil && strings.HasSuffix(value, "d") {
+			d = time.Duration(days) * 24 * time.Hour
+		} else {
+			d, err = time.ParseDuration(value)
+		}
+		if err != nil || d <= 0 {
+			return 0, args, fmt.Errorf("invalid duration %q", value)
+		}
+		return d, append(args[:i:i], args[i+2:]...), nil
+	}
+	return 0, args, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testToken          = "test-token"
+	testNetworkId      = "0123456789abcdef" // `lab`, the default network
+	testOtherNetworkId = "fedcba9876543210" // `prod`
+	testAdminId        = 1
+)
+
+// newTestCommandManager makes CommandManager managing test networks via ztApi, testAdminId is admin.
+// Besides built-in roles there is `auditor` with audit.view permission.
+func newTestCommandManager(t *testing.T, ztApi ZeroTierApi) *CommandManager {
+	t.Helper()
+	roles, err := NewRoles(map[string][]string{"auditor": {string(PermAuditView)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessManager, err := NewAccessManagerWithFileStorage(testAdminId, roles, filepath.Join(t.TempDir(), "ops.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = accessManager.Close() })
+	networks, err := NewNetworks(map[string]string{"lab": testNetworkId, "prod": testOtherNetworkId}, testNetworkId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewCallbackSigner("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := NewAuditLogFile(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = auditLog.Close() })
+	return NewCommandManager(ztApi, accessManager, networks, roles, auditLog, nil, nil, nil, signer)
+}
+
+// newTestZTApi makes ZeroTierApi of fake served by a test server, requests are not retried
+func newTestZTApi(t *testing.T, fake *FakeCentral) ZeroTierApi {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	ztApi, err := NewZTApi(ZTApiOptions{Token: testToken, BaseUrl: server.URL, MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ztApi
+}
+
+// commandMessage makes private message with command text from user chatId
+func commandMessage(chatId int64, text string) *tgbotapi.Message {
+	command := strings.SplitN(text, " ", 2)[0]
+	return &tgbotapi.Message{
+		Text:     text,
+		Chat:     &tgbotapi.Chat{ID: chatId, Type: "private"},
+		From:     &tgbotapi.User{ID: int(chatId)},
+		Entities: &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+func TestHandleMessageZeroTierErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string // token fake accepts, testToken if empty
+		failure *FakeFailure
+		command string
+		want    string
+	}{
+		{
+			name:    "invalid token",
+			token:   "other-token",
+			command: "/list",
+			want:    "The bot's ZeroTier token is invalid or has no access to the network. Contact your administrator.",
+		},
+		{
+			name:    "rate limited with Retry-After",
+			failure: &FakeFailure{Status: http.StatusTooManyRequests, RetryAfter: "30"},
+			command: "/auth 0123456789 laptop",
+			want:    "ZeroTier rate limit exceeded, the request has been rejected. Try again in 30s.",
+		},
+		{
+			name:    "rate limited",
+			failure: &FakeFailure{Status: http.StatusTooManyRequests},
+			command: "/list",
+			want:    "ZeroTier rate limit exceeded, the request has been rejected. Try again later.",
+		},
+		{
+			name:    "server error",
+			failure: &FakeFailure{Status: http.StatusInternalServerError},
+			command: "/list",
+			want:    "ZeroTier is unavailable (status 500 after 1 attempt(s)). Try again later.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if len(token) == 0 {
+				token = testToken
+			}
+			fake := NewFakeCentral(token)
+			fake.AddNetwork(testNetworkId)
+			if tt.failure != nil {
+				fake.FailNext(*tt.failure)
+			}
+			cm := newTestCommandManager(t, newTestZTApi(t, fake))
+
+			rep, err := cm.HandleMessage(context.Background(), commandMessage(testAdminId, tt.command))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+			if rep.Text != tt.want {
+				t.Errorf("HandleMessage() text = %q, want %q", rep.Text, tt.want)
+			}
+			if n := len(fake.Requests()); n != 1 {
+				t.Errorf("fake got %d requests, want 1", n)
+			}
+		})
+	}
+}
+
+// handle runs command of user and returns text of the reply
+func handle(t *testing.T, cm *CommandManager, chatId int64, text string) string {
+	t.Helper()
+	rep, err := cm.HandleMessage(context.Background(), commandMessage(chatId, text))
+	if err != nil {
+		t.Fatalf("%s: HandleMessage() error = %v", text, err)
+	}
+	return rep.Text
+}
+
+func TestAuditShowsOnlyViewableNetworks(t *testing.T) {
+	fake := NewFakeCentral(testToken)
+	fake.AddNetwork(testNetworkId)
+	fake.AddNetwork(testOtherNetworkId)
+	cm := newTestCommandManager(t, newTestZTApi(t, fake))
+	const labAuditor, auditor = 2, 3
+	handle(t, cm, testAdminId, "/role 2 auditor lab")
+	handle(t, cm, testAdminId, "/role 3 auditor")
+	handle(t, cm, testAdminId, "/auth @lab 1111111111 lab-node")
+	handle(t, cm, testAdminId, "/auth @prod 2222222222 prod-node")
+
+	got := handle(t, cm, labAuditor, "/audit")
+	if !strings.Contains(got, "1111111111") {
+		t.Errorf("auditor of lab doesn't see action in lab:\n%s", got)
+	}
+	if strings.Contains(got, "2222222222") || strings.Contains(got, "/role 3") {
+		t.Errorf("auditor of lab sees actions out of lab:\n%s", got)
+	}
+
+	got = handle(t, cm, auditor, "/audit")
+	for _, want := range []string{"1111111111", "2222222222", "/role 3 auditor"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("auditor doesn't see %q:\n%s", want, got)
+		}
+	}
+}
+
+// panicCallback is a button handler that panics
+type panicCallback struct{}
+
+func (panicCallback) HandleCallback(context.Context, *tgbotapi.CallbackQuery, []string, ZeroTierApi, AccessManager) (CallbackReply, error) {
+	panic("bad button")
+}
+
+func (panicCallback) CallbackRequires() AccessRequirement {
+	return AccessRequirement{}
+}
+
+func TestHandleCallbackRunsMiddlewares(t *testing.T) {
+	cm := newTestCommandManager(t, newTestZTApi(t, NewFakeCentral(testToken)))
+	cm.registeredCallbacks["panic"] = panicCallback{}
+	var routes []string
+	cm.UseCallbacks(func(next CallbackFunc) CallbackFunc {
+		return func(ctx context.Context, query *tgbotapi.CallbackQuery) (CallbackReply, error) {
+			routes = append(routes, callbackRoute(query))
+			return next(ctx, query)
+		}
+	}, RecoveryCallbackMiddleware)
+
+	button, err := cm.signer.Button("Press", testAdminId, time.Hour, "panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: testAdminId}, Data: *button.CallbackData}
+	_, err = cm.HandleCallback(context.Background(), query)
+	if _, ok := err.(*PanicError); !ok {
+		t.Errorf("HandleCallback() error = %v, want PanicError", err)
+	}
+	if len(routes) != 1 || routes[0] != "panic" {
+		t.Errorf("middleware saw routes %v, want [panic]", routes)
+	}
+}
+
+// pressButton presses the only button of rep as user
+func pressButton(t *testing.T, cm *CommandManager, userId int64, rep tgbotapi.MessageConfig) CallbackReply {
+	t.Helper()
+	markup, ok := rep.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || len(markup.InlineKeyboard) != 1 || len(markup.InlineKeyboard[0]) != 1 {
+		t.Fatalf("reply %q has no single button", rep.Text)
+	}
+	query := &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: int(userId)}, Data: *markup.InlineKeyboard[0][0].CallbackData}
+	callbackRep, err := cm.HandleCallback(context.Background(), query)
+	if err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+	return callbackRep
+}
+
+func TestRemoveIsConfirmedByButton(t *testing.T) {
+	const nodeId = "0123456789"
+	fake := NewFakeCentral(testToken)
+	fake.SetMember(testNetworkId, nodeId, map[string]interface{}{"name": "laptop"})
+	cm := newTestCommandManager(t, newTestZTApi(t, fake))
+
+	if got := handle(t, cm, testAdminId, "/remove "+nodeId+" confirm"); got != "Too many arguments given. Try /help." {
+		t.Errorf("/remove with confirm argument = %q, want it rejected", got)
+	}
+	rep, err := cm.HandleMessage(context.Background(), commandMessage(testAdminId, "/remove "+nodeId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests()); n != 0 {
+		t.Fatalf("fake got %d requests before confirmation, want 0", n)
+	}
+
+	// the button is bound to the user who asked for deletion
+	if got := pressButton(t, cm, 2, rep); got.Text != "Invalid button." {
+		t.Errorf("press of other user = %q, want Invalid button.", got.Text)
+	}
+	if got := pressButton(t, cm, testAdminId, rep); got.Text != "Success. "+nodeId+" has been removed from lab ("+testNetworkId+")." {
+		t.Errorf("press = %q, want success", got.Text)
+	}
+	if fake.Member(testNetworkId, nodeId) != nil {
+		t.Error("member has not been deleted")
+	}
+}
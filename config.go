@@ -5,19 +5,34 @@ import (
 	"gopkg.in/yaml.v2"
 	"log"
 	"os"
+	"time"
 )
 
 type BotConfig struct {
-	Token           string `yaml:"token"`
-	WebHookUrl      string `yaml:"web_hook_url"`
-	WebHookCertFile string `yaml:"web_hook_cert"`
-	WebHookKeyFile  string `yaml:"web_hook_key"`
-	ListenAddr      string `yaml:"listen_addr"`
-	ListenPort      string `yaml:"port"`
-	ZeroTierToken   string `yaml:"zt_token"`
-	ZeroTierNetwork string `yaml:"zt_network"`
-	AdminId         int64  `yaml:"admin_id"`
-	OpsStorage      string `yaml:"ops_file"`
+	Token            string              `yaml:"token"`
+	WebHookUrl       string              `yaml:"web_hook_url"`
+	WebHookCertFile  string              `yaml:"web_hook_cert"`
+	WebHookKeyFile   string              `yaml:"web_hook_key"`
+	ListenAddr       string              `yaml:"listen_addr"`
+	ListenPort       string              `yaml:"port"`
+	ZeroTierBackend  string              `yaml:"zt_backend"`
+	ZeroTierToken    string              `yaml:"zt_token"`
+	ZeroTierNetwork  string              `yaml:"zt_network"`
+	ZeroTierNetworks map[string]string   `yaml:"zt_networks"`
+	ZeroTierApiUrl   string              `yaml:"zt_api_url"`
+	ZeroTierTimeout  time.Duration       `yaml:"zt_timeout"`
+	ZeroTierRetries  int                 `yaml:"zt_max_retries"`
+	ZeroTierRate     float64             `yaml:"zt_rate_limit"`
+	ZeroTierBurst    int                 `yaml:"zt_burst"`
+	AdminId          int64               `yaml:"admin_id"`
+	AuditFile        string              `yaml:"audit_file"`
+	ExpiryFile       string              `yaml:"expiry_file"`
+	JoinFile         string              `yaml:"join_file"`
+	CallbackSecret   string              `yaml:"callback_secret"`
+	OpsBackend       string              `yaml:"ops_backend"`
+	OpsStorage       string              `yaml:"ops_file"`
+	Roles            map[string][]string `yaml:"roles"`
+	Workers          int                 `yaml:"workers"`
 }
 
 func LoadConfig(filename string) (BotConfig, error) {
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How soon failed unauthorization is retried
+const expiryRetryDelay = time.Minute
+
+// Notifier sends text to telegram chat
+type Notifier func(chatId int64, text string)
+
+// Expiration is a time-limited authorization of a member
+type Expiration struct {
+	NetworkId string    `json:"network_id"`
+	NodeId    string    `json:"node_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	GrantedBy int64     `json:"granted_by"`
+}
+
+// ExpiryScheduler unauthorizes members when their authorization expires and tells users who authorized them.
+// Expirations are saved to file, so that they survive restarts; those expired while the bot was down are handled on start.
+// It is safe for concurrent use.
+type ExpiryScheduler struct {
+	mu          sync.Mutex
+	filepath    string
+	expirations []Expiration
+	wake        chan struct{}
+}
+
+func NewExpiryScheduler(filepath string) (*ExpiryScheduler, error) {
+	s := &ExpiryScheduler{
+		filepath: filepath,
+		wake:     make(chan struct{}, 1),
+	}
+	fileData, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(fileData, &s.expirations); err != nil {
+		return nil, fmt.Errorf("expiry file %s: %w", filepath, err)
+	}
+	return s, nil
+}
+
+// Schedule replaces expiration of the member if there is one
+func (s *ExpiryScheduler) Schedule(e Expiration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(e.NetworkId, e.NodeId)
+	s.expirations = append(s.expirations, e)
+	if err := s.commit(); err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Cancel removes expiration of the member, e.g. when it has been authorized permanently or unauthorized
+func (s *ExpiryScheduler) Cancel(networkId, nodeId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.remove(networkId, nodeId) {
+		return nil
+	}
+	return s.commit()
+}
+
+// remove removes expiration of the member and tells if there was one, s.mu must be locked
+func (s *ExpiryScheduler) remove(networkId, nodeId string) bool {
+	for i, e := range s.expirations {
+		if e.NetworkId == networkId && e.NodeId == nodeId {
+			s.expirations = append(s.expirations[:i], s.expirations[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// commit saves expirations to file, s.mu must be locked
+func (s *ExpiryScheduler) commit() error {
+	fileData, err := json.MarshalIndent(s.expirations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filepath, fileData, 0644)
+}
+
+// due returns expirations due at now and time of the next one (zero if there are none)
+func (s *ExpiryScheduler) due(now time.Time) ([]Expiration, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.expirations, func(i, j int) bool { return s.expirations[i].ExpiresAt.Before(s.expirations[j].ExpiresAt) })
+	var due []Expiration
+	for _, e := range s.expirations {
+		if e.ExpiresAt.After(now) {
+			return due, e.ExpiresAt
+		}
+		due = append(due, e)
+	}
+	return due, time.Time{}
+}
+
+// Run unauthorizes expired members until ctx is done
+func (s *ExpiryScheduler) Run(ctx context.Context, ztApi ZeroTierApi, networks *Networks, notify Notifier) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		due, next := s.due(time.Now())
+		for _, e := range due {
+			if !s.expire(ctx, ztApi, networks, notify, e) && (next.IsZero() || next.After(time.Now().Add(expiryRetryDelay))) {
+				next = time.Now().Add(expiryRetryDelay)
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// expire unauthorizes the member and tells if it is done
+func (s *ExpiryScheduler) expire(ctx context.Context, ztApi ZeroTierApi, networks *Networks, notify Notifier, e Expiration) bool {
+	err := ztApi.UnauthMemberByID(ctx, e.NetworkId, e.NodeId)
+	if err != nil && !IsNotFound(err) {
+		log.Printf("expiry of %s in %s: %s", e.NodeId, e.NetworkId, err.Error())
+		return false
+	}
+
+	s.mu.Lock()
+	// member might have been authorized again while it was being unauthorized
+	for i, current := range s.expirations {
+		if current.NetworkId == e.NetworkId && current.NodeId == e.NodeId && current.ExpiresAt.Equal(e.ExpiresAt) {
+			s.expirations = append(s.expirations[:i], s.expirations[i+1:]...)
+			break
+		}
+	}
+	if commitErr := s.commit(); commitErr != nil {
+		log.Printf("expiry file %s: %s", s.filepath, commitErr.Error())
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		notify(e.GrantedBy, fmt.Sprintf("Authorization of %s in %s has expired, the member does not exist anymore.",
+			e.NodeId, networks.Name(e.NetworkId)))
+	} else {
+		notify(e.GrantedBy, fmt.Sprintf("Authorization of %s in %s has expired, it can't join the network anymore.",
+			e.NodeId, networks.Name(e.NetworkId)))
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpirySchedulerUnauthorizesDueMembers(t *testing.T) {
+	const nodeId = "0123456789"
+	tests := []struct {
+		name       string
+		cancel     bool
+		authorized bool // whether member is authorized afterwards
+	}{
+		{name: "due expiration", authorized: false},
+		{name: "cancelled expiration", cancel: true, authorized: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeCentral(testToken)
+			fake.SetMember(testNetworkId, nodeId, map[string]interface{}{"config": map[string]interface{}{"authorized": true}})
+			networks, err := NewNetworks(nil, testNetworkId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expiryFile := filepath.Join(t.TempDir(), "expiry.json")
+			expiry, err := NewExpiryScheduler(expiryFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = expiry.Schedule(Expiration{NetworkId: testNetworkId, NodeId: nodeId, ExpiresAt: time.Now().Add(-time.Minute), GrantedBy: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cancel {
+				if err = expiry.Cancel(testNetworkId, nodeId); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var notified []int64
+			notify := func(chatId int64, text string) { notified = append(notified, chatId) }
+			ztApi := newTestZTApi(t, fake)
+			due, _ := expiry.due(time.Now())
+			for _, e := range due {
+				if !expiry.expire(context.Background(), ztApi, networks, notify, e) {
+					t.Fatalf("expire(%+v) failed", e)
+				}
+			}
+
+			config := fake.Member(testNetworkId, nodeId)["config"].(map[string]interface{})
+			if config["authorized"] != tt.authorized {
+				t.Errorf("authorized = %v, want %v", config["authorized"], tt.authorized)
+			}
+			if wantNotified := !tt.authorized; (len(notified) == 1 && notified[0] == 2) != wantNotified {
+				t.Errorf("notified %v, want granter notified: %v", notified, wantNotified)
+			}
+			// the expiration is gone from the file as well
+			reloaded, err := NewExpiryScheduler(expiryFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if due, next := reloaded.due(time.Now()); len(due) != 0 || !next.IsZero() {
+				t.Errorf("saved expirations = %v, want none", due)
+			}
+		})
+	}
+}
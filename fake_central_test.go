@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeFailure describes an error response FakeCentral returns instead of handling a request.
+type FakeFailure struct {
+	Status     int
+	Times      int    // how many subsequent requests fail; values below 1 mean one
+	RetryAfter string // value of Retry-After header, if not empty
+}
+
+// FakeCentralRequest is a request FakeCentral has received, kept for assertions in tests.
+type FakeCentralRequest struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// FakeCentral is an in-memory stand-in of ZeroTier Central API.
+// It serves the same routes ZeroTierApi uses, so it can be wrapped with httptest.NewServer
+// and passed to NewZTApi as BaseUrl. Members are kept as raw JSON objects, so whatever the client
+// posts is merged into the stored member the same way Central does it.
+type FakeCentral struct {
+	mu       sync.Mutex
+	token    string
+	networks map[string]map[string]interface{}            // network id -> network
+	members  map[string]map[string]map[string]interface{} // network id -> node id -> member
+	failures []FakeFailure
+	requests []FakeCentralRequest
+}
+
+func NewFakeCentral(token string) *FakeCentral {
+	return &FakeCentral{
+		token:    token,
+		networks: make(map[string]map[string]interface{}),
+		members:  make(map[string]map[string]map[string]interface{}),
+	}
+}
+
+// AddNetwork creates an empty network with default config. Requests to unknown networks get 404.
+func (f *FakeCentral) AddNetwork(networkId string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addNetwork(networkId)
+}
+
+// SetNetwork merges network into stored one, creating the network if needed.
+func (f *FakeCentral) SetNetwork(networkId string, network map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addNetwork(networkId)
+	mergeJSONObjects(f.networks[networkId], network)
+}
+
+// Network returns a copy of stored network or nil if there is no such network.
+func (f *FakeCentral) Network(networkId string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	network, found := f.networks[networkId]
+	if !found {
+		return nil
+	}
+	return copyJSONObject(network)
+}
+
+// SetMember stores member as is (plus id fields), creating the network if needed.
+func (f *FakeCentral) SetMember(networkId string, nodeId string, member map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addNetwork(networkId)
+	stored := newFakeMember(networkId, nodeId)
+	mergeJSONObjects(stored, member)
+	f.members[networkId][nodeId] = stored
+}
+
+// Member returns a copy of stored member or nil if there is no such member.
+func (f *FakeCentral) Member(networkId string, nodeId string) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	member, found := f.members[networkId][nodeId]
+	if !found {
+		return nil
+	}
+	return copyJSONObject(member)
+}
+
+// FailNext makes next failure.Times requests fail with failure.Status.
+// Failures are queued, so several calls produce a sequence of errors.
+func (f *FakeCentral) FailNext(failure FakeFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if failure.Times < 1 {
+		failure.Times = 1
+	}
+	f.failures = append(f.failures, failure)
+}
+
+// Requests returns all requests received so far, including failed ones.
+func (f *FakeCentral) Requests() []FakeCentralRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCentralRequest(nil), f.requests...)
+}
+
+func (f *FakeCentral) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, "cannot read request body")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, FakeCentralRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+
+	if len(f.failures) > 0 {
+		failure := &f.failures[0]
+		failure.Times--
+		status, retryAfter := failure.Status, failure.RetryAfter
+		if failure.Times == 0 {
+			f.failures = f.failures[1:]
+		}
+		if len(retryAfter) > 0 {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		writeFakeError(w, status, http.StatusText(status))
+		return
+	}
+
+	if !f.authorized(r) {
+		writeFakeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Expected paths: /network/{id}, /network/{id}/member and /network/{id}/member/{node}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 4 || parts[0] != "network" || (len(parts) > 2 && parts[2] != "member") {
+		writeFakeError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	network, found := f.networks[parts[1]]
+	if !found {
+		writeFakeError(w, http.StatusNotFound, "Network not found")
+		return
+	}
+	members := f.members[parts[1]]
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeFakeJSON(w, network)
+		case http.MethodPost:
+			changes := make(map[string]interface{})
+			if err := json.Unmarshal(body, &changes); err != nil {
+				writeFakeError(w, http.StatusBadRequest, "Invalid JSON")
+				return
+			}
+			mergeJSONObjects(network, changes)
+			writeFakeJSON(w, network)
+		default:
+			writeFakeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+		return
+	}
+
+	if len(parts) == 3 {
+		if r.Method != http.MethodGet {
+			writeFakeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		list := make([]map[string]interface{}, 0, len(members))
+		for _, member := range members {
+			list = append(list, member)
+		}
+		writeFakeJSON(w, list)
+		return
+	}
+
+	nodeId := parts[3]
+	switch r.Method {
+	case http.MethodGet:
+		member, found := members[nodeId]
+		if !found {
+			writeFakeError(w, http.StatusNotFound, "Member not found")
+			return
+		}
+		writeFakeJSON(w, member)
+	case http.MethodPost:
+		changes := make(map[string]interface{})
+		if err := json.Unmarshal(body, &changes); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		member, found := members[nodeId]
+		if !found {
+			member = newFakeMember(parts[1], nodeId)
+			members[nodeId] = member
+		}
+		mergeJSONObjects(member, changes)
+		writeFakeJSON(w, member)
+	case http.MethodDelete:
+		member, found := members[nodeId]
+		if !found {
+			writeFakeError(w, http.StatusNotFound, "Member not found")
+			return
+		}
+		delete(members, nodeId)
+		writeFakeJSON(w, member)
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (f *FakeCentral) authorized(r *http.Request) bool {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 {
+		return false
+	}
+	scheme := strings.ToLower(fields[0])
+	return (scheme == "bearer" || scheme == "token") && fields[1] == f.token
+}
+
+// addNetwork must be called with f.mu locked
+func (f *FakeCentral) addNetwork(networkId string) {
+	if _, found := f.networks[networkId]; found {
+		return
+	}
+	f.networks[networkId] = map[string]interface{}{
+		"id": networkId,
+		"config": map[string]interface{}{
+			"id":                networkId,
+			"name":              "",
+			"private":           true,
+			"enableBroadcast":   true,
+			"mtu":               2800,
+			"multicastLimit":    32,
+			"ipAssignmentPools": []interface{}{},
+			"routes":            []interface{}{},
+			"v4AssignMode":      map[string]interface{}{"zt": false},
+			"v6AssignMode":      map[string]interface{}{"6plane": false, "rfc4193": false, "zt": false},
+			"dns":               map[string]interface{}{"domain": "", "servers": []interface{}{}},
+		},
+	}
+	f.members[networkId] = make(map[string]map[string]interface{})
+}
+
+func newFakeMember(networkId string, nodeId string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        networkId + "-" + nodeId,
+		"networkId": networkId,
+		"nodeId":    nodeId,
+		"config": map[string]interface{}{
+			"authorized":    false,
+			"ipAssignments": []interface{}{},
+		},
+	}
+}
+
+// mergeJSONObjects recursively merges src into dst: nested objects are merged, everything else is replaced.
+func mergeJSONObjects(dst map[string]interface{}, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcIsObj := v.(map[string]interface{})
+		dstObj, dstIsObj := dst[k].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			mergeJSONObjects(dstObj, srcObj)
+			continue
+		}
+		if srcIsObj {
+			v = copyJSONObject(srcObj)
+		}
+		dst[k] = v
+	}
+}
+
+func copyJSONObject(obj map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyJSONObject(nested)
+		}
+		c[k] = v
+	}
+	return c
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
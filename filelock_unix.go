@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+var FileLockedError = errors.New("file is locked by another process")
+
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile takes exclusive lock on filename, creating it if needed.
+// The lock is held until Unlock is called or the process exits.
+func lockFile(filename string) (*fileLock, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, FileLockedError
+		}
+		return nil, err
+	}
+	return &fileLock{f}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	return l.f.Close()
+}
+
+// syncDir flushes directory entries, e.g. after rename
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	_ = d.Close()
+	return err
+}
@@ -0,0 +1,44 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+var FileLockedError = errors.New("file is locked by another process")
+
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile takes exclusive lock on filename, creating it if needed.
+// The lock is held until Unlock is called or the process exits.
+func lockFile(filename string) (*fileLock, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{})
+	if err != nil {
+		_ = f.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			return nil, FileLockedError
+		}
+		return nil, err
+	}
+	return &fileLock{f}, nil
+}
+
+func (l *fileLock) Unlock() error {
+	return l.f.Close()
+}
+
+// syncDir does nothing as directories can't be synced on Windows
+func syncDir(string) error {
+	return nil
+}
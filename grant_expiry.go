@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// How often temporary grants are checked. Expired grants give no role anyway, even if they are not removed yet.
+	grantCheckInterval = time.Minute
+	// How long before expiry admin is reminded of temporary grant; half of lifetime for shorter grants
+	grantReminderLead = time.Hour
+)
+
+// RunGrantExpiry reminds admin of temporary grants expiring soon and removes expired ones until ctx is done
+func RunGrantExpiry(ctx context.Context, accessManager AccessManager, adminId int64, networks *Networks, notify Notifier) {
+	ticker := time.NewTicker(grantCheckInterval)
+	defer ticker.Stop()
+	for {
+		checkGrants(accessManager, adminId, networks, notify, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkGrants(accessManager AccessManager, adminId int64, networks *Networks, notify Notifier, now time.Time) {
+	grants, err := accessManager.TemporaryGrants()
+	if err != nil {
+		log.Printf("temporary grants: %s", err.Error())
+		return
+	}
+	for _, g := range grants {
+		if g.Reminded || g.Expired(now) {
+			continue
+		}
+		lead := grantReminderLead
+		if lifetime := g.ExpiresAt.Sub(g.GrantedAt); lifetime < 2*grantReminderLead {
+			lead = lifetime / 2
+		}
+		if now.Before(g.ExpiresAt.Add(-lead)) {
+			continue
+		}
+		// admin is reminded only once, so not at all while the reminder can't be saved
+		if err = accessManager.MarkReminded(g.UserId, g.NetworkId); err != nil {
+			log.Printf("temporary grants: %s", err.Error())
+			continue
+		}
+		notify(adminId, fmt.Sprintf("Reminder: %d is %s %s until %s, use /op or /role to extend.",
+			g.UserId, g.Role, grantScopeName(networks, g.NetworkId), g.ExpiresAt.UTC().Format("2006-01-02 15:04 MST")))
+	}
+
+	expired, err := accessManager.RemoveExpiredGrants(now)
+	if err != nil {
+		log.Printf("temporary grants: %s", err.Error())
+		return
+	}
+	for _, g := range expired {
+		notify(adminId, fmt.Sprintf("%d is not %s %s anymore: the role has expired.",
+			g.UserId, g.Role, grantScopeName(networks, g.NetworkId)))
+	}
+}
+
+func grantScopeName(networks *Networks, networkId string) string {
+	if len(networkId) == 0 {
+		return "in app"
+	}
+	return "in " + networks.Name(networkId)
+}
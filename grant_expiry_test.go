@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReminders is AccessManager that can't save reminders
+type failingReminders struct {
+	AccessManager
+}
+
+func (failingReminders) MarkReminded(int64, string) error {
+	return errors.New("disk is full")
+}
+
+func TestCheckGrants(t *testing.T) {
+	grantedAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	// step checks grants at expiry+at and expects notifications starting with want
+	type step struct {
+		at   time.Duration
+		want []string
+	}
+	tests := []struct {
+		name          string
+		lifetime      time.Duration
+		failReminders bool
+		steps         []step
+	}{
+		{
+			name:     "reminded an hour before expiry",
+			lifetime: 24 * time.Hour,
+			steps: []step{
+				{at: -61 * time.Minute},
+				{at: -59 * time.Minute, want: []string{"Reminder: 2 is operator in lab"}},
+				{at: -time.Minute},
+				{at: 0, want: []string{"2 is not operator in lab ("}},
+				{at: time.Minute},
+			},
+		},
+		{
+			name:     "short grant reminded at half of lifetime",
+			lifetime: 40 * time.Minute,
+			steps: []step{
+				{at: -21 * time.Minute},
+				{at: -19 * time.Minute, want: []string{"Reminder: 2 is operator in lab"}},
+				{at: 0, want: []string{"2 is not operator in lab ("}},
+			},
+		},
+		{
+			name:          "not reminded while reminder can't be saved",
+			lifetime:      24 * time.Hour,
+			failReminders: true,
+			steps: []step{
+				{at: -59 * time.Minute},
+				{at: -58 * time.Minute},
+				{at: 0, want: []string{"2 is not operator in lab ("}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := NewRoles(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileStorage, err := NewAccessManagerWithFileStorage(testAdminId, roles, filepath.Join(t.TempDir(), "ops.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer fileStorage.Close()
+			networks, err := NewNetworks(map[string]string{"lab": testNetworkId}, testNetworkId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expiresAt := grantedAt.Add(tt.lifetime)
+			grant := Grant{Role: RoleOperator, GrantedBy: testAdminId, GrantedAt: grantedAt, ExpiresAt: &expiresAt}
+			if err = fileStorage.SetNetworkRole(2, testNetworkId, grant); err != nil {
+				t.Fatal(err)
+			}
+			var accessManager AccessManager = fileStorage
+			if tt.failReminders {
+				accessManager = failingReminders{fileStorage}
+			}
+
+			for _, s := range tt.steps {
+				var got []string
+				notify := func(chatId int64, text string) {
+					if chatId != testAdminId {
+						t.Errorf("notification to %d, want admin", chatId)
+					}
+					got = append(got, text)
+				}
+				checkGrants(accessManager, testAdminId, networks, notify, expiresAt.Add(s.at))
+				if len(got) != len(s.want) {
+					t.Errorf("at expiry%+v: notifications %q, want %q", s.at, got, s.want)
+					continue
+				}
+				for i := range got {
+					if !strings.HasPrefix(got[i], s.want[i]) {
+						t.Errorf("at expiry%+v: notification %q, want %q...", s.at, got[i], s.want[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAuditEntries = 10
+	// telegram message can't be longer than 4096 characters
+	maxAuditEntries = 20
+	// length of args and result shown
+	auditTextLimit = 60
+)
+
+/* /audit handler */
+type AuditHandler struct {
+	networks *Networks
+	auditLog AuditLog
+}
+
+func (h AuditHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	args := splitArgs(msg.CommandArguments())
+	if len(args) > 2 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
+	}
+	n := defaultAuditEntries
+	// a short number is count of entries, a long one is user id
+	if len(args) > 0 && len(args[0]) <= 2 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil || n <= 0 || n > maxAuditEntries {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				fmt.Sprintf("Invalid argument: number of entries must be from 1 to %d.", maxAuditEntries)), nil
+		}
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Invalid argument. Try /help."), nil
+	}
+
+	filter := firstArg(args)
+	// users with audit.view only in some networks see only actions in those networks
+	global := accessManager.HasPermission(msg.Chat.ID, "", PermAuditView)
+	visible := make(map[string]bool)
+	entries, err := h.auditLog.Last(n, func(e *AuditEntry) bool {
+		if len(filter) > 0 && !e.Matches(filter) {
+			return false
+		}
+		if global {
+			return true
+		}
+		if len(e.Network) == 0 {
+			return false
+		}
+		if _, found := visible[e.Network]; !found {
+			visible[e.Network] = accessManager.HasPermission(msg.Chat.ID, e.Network, PermAuditView)
+		}
+		return visible[e.Network]
+	})
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+	if len(entries) == 0 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "No entries."), nil
+	}
+	txt := ""
+	for _, e := range entries {
+		actor := strconv.FormatInt(e.ActorId, 10)
+		if len(e.ActorName) > 0 {
+			actor = fmt.Sprintf("@%s (%s)", e.ActorName, actor)
+		}
+		txt += fmt.Sprintf("%s %s: /%s %s\n=> %s\n", e.Time.Format("2006-01-02 15:04:05"), actor,
+			e.Command, shorten(e.Args), shorten(e.Result))
+	}
+	return tgbotapi.NewMessage(msg.Chat.ID, txt), nil
+}
+
+func (AuditHandler) Description() string {
+	return fmt.Sprintf("Shows last n (%d by default, up to %d) privileged actions in networks where you can view them, "+
+		"only of given user (id or @username) or on given NodeID if it's given. Usage:`/audit [n] [user|NodeID]`.",
+		defaultAuditEntries, maxAuditEntries)
+}
+
+func (AuditHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermAuditView}
+}
+
+// shorten returns first line of s cut to auditTextLimit characters
+func shorten(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i] + "…"
+	}
+	if r := []rune(s); len(r) > auditTextLimit {
+		s = string(r[:auditTextLimit]) + "…"
+	}
+	return s
+}
@@ -1,18 +1,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"time"
 )
 
 /* /auth handler */
-type AuthHandler struct{}
+type AuthHandler struct {
+	networks *Networks
+	expiry   *ExpiryScheduler // nil if time-limited authorization is disabled
+}
 
-func (AuthHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
-	if accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelOperator {
-		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-	}
+func (h AuthHandler) Handle(ctx context.Context, msg *tgbotapi.Message, ztApi ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
 	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
+	lifetime, args, err := popDurationFlag(args, "--for")
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Invalid argument: %s.", err.Error())), nil
+	}
+	if lifetime > 0 && h.expiry == nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Time-limited authorization is disabled."), nil
+	}
 	if len(args) == 0 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
 	}
@@ -24,58 +38,103 @@ func (AuthHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, accessManag
 	if len(args) == 2 {
 		shortname = args[1]
 	}
-	success, err := ztApi.AuthMember(
-		ztApi.defaultNetwork, nodeId, shortname,
+	err = ztApi.AuthMember(ctx,
+		networkId, nodeId, shortname,
 		fmt.Sprintf("added by via telegram bot by %d", msg.Chat.ID))
 	if err != nil {
 		if err == InvalidNodeId {
 			return tgbotapi.NewMessage(msg.Chat.ID,
 				"Invalid NodeID"), nil
 		}
+		if IsNotFound(err) {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				fmt.Sprintf("Failed to authorize %s: network %s not found.", nodeId, h.networks.Name(networkId))), nil
+		}
 		return tgbotapi.MessageConfig{}, err
 	}
-	if success {
-		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. %s can now join %s", nodeId, ztApi.defaultNetwork)), nil
+	if lifetime > 0 {
+		expiresAt := time.Now().Add(lifetime).UTC()
+		err = h.expiry.Schedule(Expiration{NetworkId: networkId, NodeId: nodeId, ExpiresAt: expiresAt, GrantedBy: msg.Chat.ID})
+		if err != nil {
+			log.Println(err)
+			return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("%s can now join %s, but its expiry has not been saved. "+
+				"Unauthorize it manually.", nodeId, h.networks.Name(networkId))), nil
+		}
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. %s can now join %s until %s",
+			nodeId, h.networks.Name(networkId), expiresAt.Format("2006-01-02 15:04 MST"))), nil
 	}
-	return tgbotapi.NewMessage(msg.Chat.ID,
-		fmt.Sprintf("Failed to authorize %s in %s!", ztApi.defaultNetwork, args[0])), nil
-
+	// authorization is permanent now
+	cancelExpiry(h.expiry, networkId, nodeId)
+	return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. %s can now join %s", nodeId, h.networks.Name(networkId))), nil
 }
 
 func (AuthHandler) Description() string {
-	return "Authorizes given NodeID in network. Usage:`/auth NodeID short_name`."
+	return "Authorizes given NodeID in network, until given time passes (e.g. 8h or 3d) if --for is given. " +
+		"Usage:`/auth [@network] NodeID short_name [--for duration]`."
+}
+
+func (AuthHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersAuth, NetworkScoped: true}
+}
+
+func (h AuthHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	networkId, args, _ := h.networks.FromArgs(msg.Chat.ID, splitArgs(msg.CommandArguments()))
+	return networkId, firstArg(args), true
 }
 
 /* /unauth handler */
-type UnauthHandler struct{}
+type UnauthHandler struct {
+	networks *Networks
+	expiry   *ExpiryScheduler
+}
 
-func (UnauthHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
-	if accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelOperator {
-		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-	}
+func (h UnauthHandler) Handle(ctx context.Context, msg *tgbotapi.Message, ztApi ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
 	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
 	if len(args) == 0 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
 	}
 	if len(args) > 1 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
 	}
-	success, err := ztApi.UnauthMemberByID(ztApi.defaultNetwork, args[0])
+	err = ztApi.UnauthMemberByID(ctx, networkId, args[0])
 	if err != nil {
 		if err == InvalidNodeId {
 			return tgbotapi.NewMessage(msg.Chat.ID,
 				"Invalid NodeID"), nil
 		}
+		if IsNotFound(err) {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				fmt.Sprintf("Failed to unauthorize %s: network %s not found.", args[0], h.networks.Name(networkId))), nil
+		}
 		return tgbotapi.MessageConfig{}, err
 	}
-	if success {
-		return tgbotapi.NewMessage(msg.Chat.ID, "Success."), nil
-	}
-	return tgbotapi.NewMessage(msg.Chat.ID,
-		fmt.Sprintf("Failed to unauthorize %s in %s!", ztApi.defaultNetwork, args[0])), nil
-
+	cancelExpiry(h.expiry, networkId, args[0])
+	return tgbotapi.NewMessage(msg.Chat.ID, "Success."), nil
 }
 
 func (UnauthHandler) Description() string {
-	return "Unauthorizes given NodeID in network. Usage:`/unauth NodeID`."
+	return "Unauthorizes given NodeID in network. Usage:`/unauth [@network] NodeID`."
+}
+
+func (UnauthHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersUnauth, NetworkScoped: true}
+}
+
+func (h UnauthHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	networkId, args, _ := h.networks.FromArgs(msg.Chat.ID, splitArgs(msg.CommandArguments()))
+	return networkId, firstArg(args), true
+}
+
+// cancelExpiry cancels time limit of member's authorization if there is one
+func cancelExpiry(expiry *ExpiryScheduler, networkId, nodeId string) {
+	if expiry == nil {
+		return
+	}
+	if err := expiry.Cancel(networkId, nodeId); err != nil {
+		log.Println(err)
+	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
@@ -8,10 +9,31 @@ import (
 /* /start handler */
 type StartHandler struct{}
 
-func (StartHandler) Handle(msg *tgbotapi.Message, _ *ZeroTierApi, _ AccessManager) (tgbotapi.MessageConfig, error) {
+func (StartHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, _ AccessManager) (tgbotapi.MessageConfig, error) {
 	return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Hello, %d!", msg.Chat.ID)), nil
 }
 
 func (StartHandler) Description() string {
 	return "begins interaction with me"
 }
+
+func (StartHandler) Requires() AccessRequirement {
+	return AccessRequirement{}
+}
+
+/* /help handler */
+type HelpHandler struct {
+	cm *CommandManager
+}
+
+func (h HelpHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, _ AccessManager) (tgbotapi.MessageConfig, error) {
+	return tgbotapi.NewMessage(msg.Chat.ID, h.cm.HelpText(msg.Chat.ID)), nil
+}
+
+func (HelpHandler) Description() string {
+	return "provides help."
+}
+
+func (HelpHandler) Requires() AccessRequirement {
+	return AccessRequirement{}
+}
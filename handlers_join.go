@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"strconv"
+)
+
+// Route of join request buttons, their args are network id, action and request id
+const (
+	joinCallbackRoute = "join"
+	joinActionApprove = "approve"
+	joinActionDeny    = "deny"
+)
+
+/* /join handler */
+type JoinHandler struct {
+	networks *Networks
+	requests *JoinRequests
+	send     MessageSender
+	signer   *CallbackSigner
+	expiry   *ExpiryScheduler // nil if time-limited authorization is disabled
+}
+
+func (h JoinHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
+	if len(args) == 0 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
+	}
+	if len(args) > 2 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
+	}
+	nodeId := args[0]
+	if err := validateIds(networkId, nodeId); err == InvalidNodeId {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Invalid NodeID"), nil
+	}
+	req := JoinRequest{
+		NetworkId:   networkId,
+		NodeId:      nodeId,
+		Name:        firstArg(args[1:]),
+		RequesterId: msg.Chat.ID,
+	}
+	if msg.From != nil {
+		req.RequesterName = msg.From.UserName
+	}
+
+	req, err = h.requests.Create(req)
+	if err == JoinRequestExists {
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Request to join %s with %s is already pending until %s.",
+			h.networks.Name(networkId), nodeId, req.ExpiresAt.Format("2006-01-02 15:04 MST"))), nil
+	}
+	if err == TooManyJoinRequests {
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("You can't have more than %d pending requests. "+
+			"Wait until they are decided.", maxJoinRequestsPerUser)), nil
+	}
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	approvers, err := accessManager.UsersWithPermission(networkId, PermMembersAuth)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+	requester := strconv.FormatInt(req.RequesterId, 10)
+	if len(req.RequesterName) > 0 {
+		requester = fmt.Sprintf("@%s (%s)", req.RequesterName, requester)
+	}
+	text := fmt.Sprintf("%s asks to join %s with %s", requester, h.networks.Name(networkId), nodeId)
+	if len(req.Name) > 0 {
+		text += fmt.Sprintf(" named %q", req.Name)
+	}
+	text += "."
+	notified := 0
+	for _, id := range approvers {
+		notification := tgbotapi.NewMessage(id, text)
+		approve, err := h.signer.Button("Approve", id, joinRequestLifetime, joinCallbackRoute, networkId, joinActionApprove, req.Id)
+		if err != nil {
+			return tgbotapi.MessageConfig{}, err
+		}
+		deny, err := h.signer.Button("Deny", id, joinRequestLifetime, joinCallbackRoute, networkId, joinActionDeny, req.Id)
+		if err != nil {
+			return tgbotapi.MessageConfig{}, err
+		}
+		notification.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(approve, deny))
+		if err := h.send(notification); err != nil {
+			log.Printf("join request %s to %d: %s", req.Id, id, err.Error())
+			continue
+		}
+		notified++
+	}
+	if notified == 0 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Request has been saved, but no operator could be notified. "+
+			"Contact your administrator."), nil
+	}
+	return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Request has been sent to operators, you will be told "+
+		"when it is decided. It expires in %s.", joinRequestLifetime)), nil
+}
+
+func (JoinHandler) Description() string {
+	return "Asks operators to authorize given NodeID in network. Usage:`/join [@network] NodeID [short_name]`."
+}
+
+func (JoinHandler) Requires() AccessRequirement {
+	return AccessRequirement{NetworkScoped: true}
+}
+
+// HandleCallback approves or denies join request by press of its button
+func (h JoinHandler) HandleCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string, ztApi ZeroTierApi, _ AccessManager) (CallbackReply, error) {
+	if len(args) != 3 || (args[1] != joinActionApprove && args[1] != joinActionDeny) {
+		return CallbackReply{Text: "Unknown button."}, nil
+	}
+	networkId, action, requestId := args[0], args[1], args[2]
+	userId := int64(query.From.ID)
+	original := ""
+	if query.Message != nil {
+		original = query.Message.Text + "\n\n"
+	}
+	notPending := CallbackReply{Text: "The request is already decided or has expired.", EditText: original + "Not pending anymore."}
+	req, err := h.requests.Get(requestId)
+	if err == JoinRequestNotFound {
+		return notPending, nil
+	}
+	if err != nil {
+		return CallbackReply{}, err
+	}
+	// access has been checked in network of the button, it must be the request's one
+	if req.NetworkId != networkId {
+		return CallbackReply{Text: "Invalid button."}, nil
+	}
+	// taken, so that another operator can't decide it at the same time
+	req, err = h.requests.Take(requestId)
+	if err == JoinRequestNotFound {
+		return notPending, nil
+	}
+	if err != nil {
+		return CallbackReply{}, err
+	}
+
+	decider := strconv.FormatInt(userId, 10)
+	if len(query.From.UserName) > 0 {
+		decider = "@" + query.From.UserName
+	}
+	if action == joinActionDeny {
+		h.notify(req.RequesterId, fmt.Sprintf("Your request to join %s with %s has been denied.",
+			h.networks.Name(req.NetworkId), req.NodeId))
+		return CallbackReply{Text: "Denied.", EditText: original + "Denied by " + decider + "."}, nil
+	}
+
+	err = ztApi.AuthMember(ctx, req.NetworkId, req.NodeId, req.Name,
+		fmt.Sprintf("joined via telegram bot by %d, approved by %d", req.RequesterId, userId))
+	if err != nil {
+		if restoreErr := h.requests.Restore(req); restoreErr != nil {
+			log.Printf("join request %s: %s", req.Id, restoreErr.Error())
+		}
+		if IsNotFound(err) {
+			return CallbackReply{Text: fmt.Sprintf("Failed to authorize %s: network %s not found.",
+				req.NodeId, h.networks.Name(req.NetworkId))}, nil
+		}
+		return CallbackReply{}, err
+	}
+	// authorization is permanent now
+	cancelExpiry(h.expiry, req.NetworkId, req.NodeId)
+	h.notify(req.RequesterId, fmt.Sprintf("Your request to join %s with %s has been approved, it can join the network now.",
+		h.networks.Name(req.NetworkId), req.NodeId))
+	return CallbackReply{Text: "Approved.", EditText: original + "Approved by " + decider + "."}, nil
+}
+
+func (JoinHandler) CallbackRequires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersAuth, NetworkScoped: true}
+}
+
+func (h JoinHandler) CallbackAuditTarget(args []string) (string, string, bool) {
+	if len(args) != 3 {
+		return "", "", false
+	}
+	// the request is looked up before it is decided
+	if req, err := h.requests.Get(args[2]); err == nil {
+		return args[0], req.NodeId, true
+	}
+	return args[0], args[2], true
+}
+
+// notify sends text to user, failures are logged
+func (h JoinHandler) notify(chatId int64, text string) {
+	if err := h.send(tgbotapi.NewMessage(chatId, text)); err != nil {
+		log.Printf("notification to %d: %s", chatId, err.Error())
+	}
+}
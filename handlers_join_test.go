@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Approved member is authorized permanently, so its earlier time limit must not unauthorize it
+func TestJoinApproveCancelsExpiry(t *testing.T) {
+	const nodeId, requesterId = "0123456789", 2
+	fake := NewFakeCentral(testToken)
+	fake.AddNetwork(testNetworkId)
+	networks, err := NewNetworks(nil, testNetworkId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests, err := NewJoinRequests(filepath.Join(t.TempDir(), "join.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiry, err := NewExpiryScheduler(filepath.Join(t.TempDir(), "expiry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = expiry.Schedule(Expiration{NetworkId: testNetworkId, NodeId: nodeId, ExpiresAt: time.Now().Add(time.Hour), GrantedBy: testAdminId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := requests.Create(JoinRequest{NetworkId: testNetworkId, NodeId: nodeId, RequesterId: requesterId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(tgbotapi.MessageConfig) error { return nil }
+	h := JoinHandler{networks, requests, send, nil, expiry}
+
+	query := &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: testAdminId}}
+	rep, err := h.HandleCallback(context.Background(), query, []string{testNetworkId, joinActionApprove, req.Id}, newTestZTApi(t, fake), nil)
+	if err != nil {
+		t.Fatalf("HandleCallback() error = %v", err)
+	}
+	if rep.Text != "Approved." {
+		t.Fatalf("HandleCallback() text = %q, want Approved.", rep.Text)
+	}
+	if due, next := expiry.due(time.Now().Add(2 * time.Hour)); len(due) != 0 || !next.IsZero() {
+		t.Errorf("expirations after approval = %v, want none", due)
+	}
+}
@@ -2,19 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"text/template"
 )
 
 /* /list handler */
-type ListMembersHandler struct{}
+type ListMembersHandler struct {
+	networks *Networks
+}
 
-func (ListMembersHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
-	if accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelOperator {
-		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-	}
+func (h ListMembersHandler) Handle(ctx context.Context, msg *tgbotapi.Message, ztApi ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
 	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
 	if len(args) > 1 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
 	}
@@ -30,16 +34,15 @@ func (ListMembersHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, acce
 			return tgbotapi.NewMessage(msg.Chat.ID, "Invalid argument. Try /help."), nil
 		}
 	}
-	members, err := ztApi.ListMembers(ztApi.defaultNetwork)
+	members, err := ztApi.ListMembers(ctx, networkId)
 	if err != nil {
+		if IsNotFound(err) {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				fmt.Sprintf("Failed to get members: network %s not found.", h.networks.Name(networkId))), nil
+		}
 		return tgbotapi.MessageConfig{}, err
 	}
 
-	if members == nil {
-		return tgbotapi.NewMessage(msg.Chat.ID,
-			fmt.Sprintf("Failed to get members of %s.", ztApi.defaultNetwork)), nil
-	}
-
 	mList.Members = members
 
 	var tStr = "{{$verbose := .Verbose}}" +
@@ -80,5 +83,9 @@ func (ListMembersHandler) Handle(msg *tgbotapi.Message, ztApi *ZeroTierApi, acce
 }
 
 func (ListMembersHandler) Description() string {
-	return "Lists all nodes in network. Use -v if you want more details. Usage:`/list [-v]`."
+	return "Lists all nodes in network. Use -v if you want more details. Usage:`/list [@network] [-v]`."
+}
+
+func (ListMembersHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersList, NetworkScoped: true}
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Value meaning "empty list" for list settings of /network
+const networkNoneValue = "none"
+
+// ZeroTier's limits for network MTU
+const (
+	minNetworkMtu = 1280
+	maxNetworkMtu = 10000
+)
+
+// networkSetting parses value of a /network setting into network changes.
+// current is the network config before change, so settings of compound fields can keep the rest of them.
+type networkSetting func(value string, current *NetworkConfig) (*NetworkConfigUpdate, error)
+
+var networkSettings = map[string]networkSetting{
+	"name": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		if len(value) == 0 {
+			return nil, errors.New("name can't be empty")
+		}
+		return &NetworkConfigUpdate{Name: stringPtr(value)}, nil
+	},
+	"private": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		private, err := parseNetworkBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return &NetworkConfigUpdate{Private: boolPtr(private)}, nil
+	},
+	"broadcast": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		broadcast, err := parseNetworkBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return &NetworkConfigUpdate{EnableBroadcast: boolPtr(broadcast)}, nil
+	},
+	"mtu": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		mtu, err := strconv.Atoi(value)
+		if err != nil || mtu < minNetworkMtu || mtu > maxNetworkMtu {
+			return nil, fmt.Errorf("MTU must be a number from %d to %d", minNetworkMtu, maxNetworkMtu)
+		}
+		return &NetworkConfigUpdate{Mtu: intPtr(mtu)}, nil
+	},
+	"multicast_limit": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, errors.New("multicast limit must be a non-negative number")
+		}
+		return &NetworkConfigUpdate{MulticastLimit: intPtr(limit)}, nil
+	},
+	"v4_assign": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		zt, err := parseNetworkBool(value)
+		if err != nil {
+			return nil, err
+		}
+		return &NetworkConfigUpdate{V4AssignMode: &V4AssignMode{Zt: zt}}, nil
+	},
+	"v6_assign": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		mode := &V6AssignMode{}
+		for _, m := range splitNetworkList(value) {
+			switch m {
+			case "zt":
+				mode.Zt = true
+			case "rfc4193":
+				mode.Rfc4193 = true
+			case "6plane":
+				mode.SixPlane = true
+			default:
+				return nil, fmt.Errorf("unknown IPv6 assign mode %q, use zt, rfc4193 and 6plane", m)
+			}
+		}
+		return &NetworkConfigUpdate{V6AssignMode: mode}, nil
+	},
+	"pools": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		pools := make([]IpAssignmentPool, 0)
+		for _, p := range splitNetworkList(value) {
+			pool, err := parseIpAssignmentPool(p)
+			if err != nil {
+				return nil, err
+			}
+			pools = append(pools, pool)
+		}
+		return &NetworkConfigUpdate{IpAssignmentPools: &pools}, nil
+	},
+	"routes": func(value string, _ *NetworkConfig) (*NetworkConfigUpdate, error) {
+		routes := make([]NetworkRoute, 0)
+		for _, r := range splitNetworkList(value) {
+			route, err := parseNetworkRoute(r)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, route)
+		}
+		return &NetworkConfigUpdate{Routes: &routes}, nil
+	},
+	"dns_domain": func(value string, current *NetworkConfig) (*NetworkConfigUpdate, error) {
+		dns := current.DNS
+		if value == networkNoneValue {
+			value = ""
+		}
+		if strings.ContainsAny(value, " /:") {
+			return nil, errors.New("invalid domain name")
+		}
+		dns.Domain = value
+		return &NetworkConfigUpdate{DNS: &dns}, nil
+	},
+	"dns_servers": func(value string, current *NetworkConfig) (*NetworkConfigUpdate, error) {
+		dns := current.DNS
+		dns.Servers = make([]string, 0)
+		for _, s := range splitNetworkList(value) {
+			if net.ParseIP(s) == nil {
+				return nil, fmt.Errorf("%q is not an IP address", s)
+			}
+			dns.Servers = append(dns.Servers, s)
+		}
+		return &NetworkConfigUpdate{DNS: &dns}, nil
+	},
+}
+
+func networkSettingNames() []string {
+	names := make([]string, 0, len(networkSettings))
+	for name := range networkSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseNetworkBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on":
+		return true, nil
+	case "false", "no", "off":
+		return false, nil
+	}
+	return false, errors.New("value must be on or off")
+}
+
+// splitNetworkList splits comma-separated list; networkNoneValue means empty list
+func splitNetworkList(value string) []string {
+	if value == networkNoneValue {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseIpAssignmentPool parses `start-end`
+func parseIpAssignmentPool(value string) (IpAssignmentPool, error) {
+	bounds := strings.Split(value, "-")
+	if len(bounds) != 2 {
+		return IpAssignmentPool{}, fmt.Errorf("invalid pool %q, use start-end", value)
+	}
+	start, end := net.ParseIP(bounds[0]), net.ParseIP(bounds[1])
+	if start == nil || end == nil {
+		return IpAssignmentPool{}, fmt.Errorf("invalid pool %q: bounds must be IP addresses", value)
+	}
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return IpAssignmentPool{}, fmt.Errorf("invalid pool %q: bounds must be of the same IP version", value)
+	}
+	if bytes.Compare(start.To16(), end.To16()) > 0 {
+		return IpAssignmentPool{}, fmt.Errorf("invalid pool %q: start is greater than end", value)
+	}
+	return IpAssignmentPool{IpRangeStart: start.String(), IpRangeEnd: end.String()}, nil
+}
+
+// parseNetworkRoute parses `target` or `target@via`
+func parseNetworkRoute(value string) (NetworkRoute, error) {
+	parts := strings.Split(value, "@")
+	if len(parts) > 2 {
+		return NetworkRoute{}, fmt.Errorf("invalid route %q, use target or target@via", value)
+	}
+	_, target, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return NetworkRoute{}, fmt.Errorf("invalid route %q: target must be a CIDR", value)
+	}
+	route := NetworkRoute{Target: target.String()}
+	if len(parts) == 2 {
+		via := net.ParseIP(parts[1])
+		if via == nil {
+			return NetworkRoute{}, fmt.Errorf("invalid route %q: via must be an IP address", value)
+		}
+		if (via.To4() == nil) != (target.IP.To4() == nil) {
+			return NetworkRoute{}, fmt.Errorf("invalid route %q: target and via must be of the same IP version", value)
+		}
+		route.Via = stringPtr(via.String())
+	}
+	return route, nil
+}
+
+var networkTemplate = template.Must(template.New("network").Parse(
+	"Network: {{.ID}}\n" +
+		"Name: {{.Config.Name}}\n" +
+		"{{if .Description}}Description: {{.Description}}\n{{end}}" +
+		"{{if .TotalMemberCount}}Members: {{.TotalMemberCount}} ({{.AuthorizedMemberCount}} authorized, {{.OnlineMemberCount}} online)\n{{end}}" +
+		"Private: {{.Config.Private}}\n" +
+		"Broadcast: {{.Config.EnableBroadcast}}\n" +
+		"MTU: {{.Config.Mtu}}\n" +
+		"Multicast limit: {{.Config.MulticastLimit}}\n" +
+		"IPv4 auto-assign: {{.Config.V4AssignMode.Zt}}\n" +
+		"IPv6 auto-assign: zt={{.Config.V6AssignMode.Zt}} rfc4193={{.Config.V6AssignMode.Rfc4193}} 6plane={{.Config.V6AssignMode.SixPlane}}\n" +
+		"IP assignment pools:\n" +
+		"{{range .Config.IpAssignmentPools}}" +
+		"> {{.IpRangeStart}} - {{.IpRangeEnd}}\n" +
+		"{{else}}" +
+		"None\n" +
+		"{{end}}" +
+		"Managed routes:\n" +
+		"{{range .Config.Routes}}" +
+		"> {{.Target}}{{if .Via}} via {{.Via}}{{end}}\n" +
+		"{{else}}" +
+		"None\n" +
+		"{{end}}" +
+		"DNS domain: {{.Config.DNS.Domain}}\n" +
+		"DNS servers:\n" +
+		"{{range .Config.DNS.Servers}}" +
+		"> {{.}}\n" +
+		"{{else}}" +
+		"None\n" +
+		"{{end}}"))
+
+/* /network handler */
+type NetworkHandler struct {
+	networks *Networks
+}
+
+func (h NetworkHandler) Handle(ctx context.Context, msg *tgbotapi.Message, ztApi ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
+	if len(args) > 0 {
+		if args[0] != "set" {
+			return tgbotapi.NewMessage(msg.Chat.ID, "Invalid argument. Try /help."), nil
+		}
+		if !accessManager.HasPermission(msg.Chat.ID, networkId, PermNetworkEdit) {
+			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
+		}
+		if len(args) < 3 {
+			return tgbotapi.NewMessage(msg.Chat.ID, "Not enough arguments given. Try /help."), nil
+		}
+	}
+
+	network, err := ztApi.GetNetwork(ctx, networkId)
+	if err != nil {
+		if IsNotFound(err) {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				fmt.Sprintf("Network %s not found.", h.networks.Name(networkId))), nil
+		}
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	if len(args) > 0 {
+		setting, found := networkSettings[args[1]]
+		if !found {
+			return tgbotapi.NewMessage(msg.Chat.ID,
+				"Unknown setting. Available settings: "+strings.Join(networkSettingNames(), ", ")+"."), nil
+		}
+		changes, err := setting(strings.Join(args[2:], " "), &network.Config)
+		if err != nil {
+			return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Invalid value: %s.", err.Error())), nil
+		}
+		network, err = ztApi.UpdateNetwork(ctx, networkId, &NetworkUpdate{Config: changes})
+		if err != nil {
+			return tgbotapi.MessageConfig{}, err
+		}
+	}
+
+	repBuf := bytes.NewBufferString("")
+	err = networkTemplate.Execute(repBuf, network)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+
+	return tgbotapi.NewMessage(msg.Chat.ID, repBuf.String()), nil
+}
+
+func (NetworkHandler) Description() string {
+	return "Shows network settings. Users with network.edit permission can change them: list settings are comma-separated (`none` for empty), " +
+		"pools are `start-end`, routes are `target[@via]`, v6_assign takes zt, rfc4193 and 6plane. " +
+		"Usage:`/network [@network] [set setting value]`."
+}
+
+func (NetworkHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermNetworkView, NetworkScoped: true}
+}
+
+// Only `set` is audited, target is the setting
+func (h NetworkHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	networkId, args, _ := h.networks.FromArgs(msg.Chat.ID, splitArgs(msg.CommandArguments()))
+	if len(args) == 0 || args[0] != "set" {
+		return "", "", false
+	}
+	return networkId, firstArg(args[1:]), true
+}
+
+/* /use handler */
+type UseHandler struct {
+	networks *Networks
+}
+
+func (h UseHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	args := splitArgs(msg.CommandArguments())
+	if len(args) > 1 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
+	}
+
+	if len(args) == 1 {
+		networkId, err := h.networks.Resolve(args[0])
+		if err != nil {
+			return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+		}
+		if !accessManager.HasPermission(msg.Chat.ID, networkId, PermNetworkView) {
+			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
+		}
+		if _, err = h.networks.SetCurrent(msg.Chat.ID, networkId); err != nil {
+			return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+		}
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. Current network is %s now.", h.networks.Name(networkId))), nil
+	}
+
+	// only networks user can act on are listed
+	current, _ := h.networks.Current(msg.Chat.ID)
+	txt := "Networks:\n"
+	for _, networkId := range h.networks.List() {
+		if !accessManager.HasPermission(msg.Chat.ID, networkId, PermNetworkView) {
+			continue
+		}
+		if networkId == current {
+			txt += "> " + h.networks.Name(networkId) + " (current)\n"
+		} else {
+			txt += "> " + h.networks.Name(networkId) + "\n"
+		}
+	}
+	return tgbotapi.NewMessage(msg.Chat.ID, txt), nil
+}
+
+func (UseHandler) Description() string {
+	return "Selects network which commands act on when no `@network` is given, lists networks if no arguments given. Usage:`/use [network]`."
+}
+
+func (UseHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermNetworkView}
+}
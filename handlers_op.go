@@ -1,24 +1,36 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"strconv"
+	"strings"
+	"time"
 )
 
-/* /op handler */
-type OpHandler struct{}
-
-func (OpHandler) Handle(msg *tgbotapi.Message, _ *ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
-	if accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelAdmin {
-		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
+// setRoleByArgs handles `user_id [network] [--for duration]` arguments of /op, /deop and /role.
+// Role is set globally if no network is given, otherwise in the network only.
+// Changing global roles requires users.manage globally, changing network-scoped ones requires it in the network.
+func setRoleByArgs(msg *tgbotapi.Message, args []string, accessManager AccessManager, networks *Networks, role string) (tgbotapi.MessageConfig, error) {
+	lifetime, args, err := popDurationFlag(args, "--for")
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Invalid argument: %s.", err.Error())), nil
+	}
+	grant := Grant{Role: role, GrantedBy: msg.Chat.ID}
+	until := ""
+	if lifetime > 0 {
+		if role == RoleGuest {
+			return tgbotapi.NewMessage(msg.Chat.ID, "Guest role can't be temporary."), nil
+		}
+		expiresAt := time.Now().Add(lifetime).UTC()
+		grant.ExpiresAt = &expiresAt
+		until = ", until " + expiresAt.Format("2006-01-02 15:04 MST")
 	}
-
-	args := splitArgs(msg.CommandArguments())
 	if len(args) == 0 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
 	}
-	if len(args) > 1 {
+	if len(args) > 2 {
 		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
 	}
 
@@ -27,51 +39,130 @@ func (OpHandler) Handle(msg *tgbotapi.Message, _ *ZeroTierApi, accessManager Acc
 		return tgbotapi.NewMessage(msg.Chat.ID, "Invalid argument. Try /help."), nil
 	}
 
-	err = accessManager.SetAccessLevel(id, AccessLevelOperator)
+	txt := fmt.Sprintf("Success. %d is %s in app now%s.", id, role, until)
+	if len(args) == 2 {
+		var networkId string
+		networkId, err = networks.Resolve(args[1])
+		if err != nil {
+			return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+		}
+		if !accessManager.HasPermission(msg.Chat.ID, networkId, PermUsersManage) {
+			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
+		}
+		err = accessManager.SetNetworkRole(id, networkId, grant)
+		txt = fmt.Sprintf("Success. %d is %s in %s now%s.", id, role, networks.Name(networkId), until)
+		if role == RoleGuest {
+			// guest role only removes network-scoped role
+			txt = fmt.Sprintf("Success. %d has the same rights in %s as in app now.", id, networks.Name(networkId))
+		}
+	} else {
+		if !accessManager.HasPermission(msg.Chat.ID, "", PermUsersManage) {
+			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
+		}
+		err = accessManager.SetRole(id, grant)
+	}
 	if err != nil {
 		if err == AdminMutationError {
 			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
 		}
+		if err == InvalidRoleError {
+			return tgbotapi.NewMessage(msg.Chat.ID, "Unknown role. Try /help."), nil
+		}
 		return tgbotapi.MessageConfig{}, err
 	}
-	return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. %d is an operator now.", id)), nil
+	return tgbotapi.NewMessage(msg.Chat.ID, txt), nil
+}
+
+// roleAuditTarget returns network and user of `user_id [network] [--for duration]` arguments
+func roleAuditTarget(networks *Networks, args []string) (string, string, bool) {
+	_, args, _ = popDurationFlag(args, "--for")
+	if len(args) < 2 {
+		return "", firstArg(args), true
+	}
+	networkId, err := networks.Resolve(args[1])
+	if err != nil {
+		return args[1], args[0], true
+	}
+	return networkId, args[0], true
+}
+
+/* /op handler */
+type OpHandler struct {
+	networks *Networks
+}
+
+func (h OpHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	return setRoleByArgs(msg, splitArgs(msg.CommandArguments()), accessManager, h.networks, RoleOperator)
 }
 
 func (OpHandler) Description() string {
-	return "Makes user with given user_id (number) an operator in app. Usage:`/op user_id`."
+	return "Makes user with given user_id (number) an operator in app or only in given network, " +
+		"until given time passes (e.g. 24h or 2d) if --for is given. Usage:`/op user_id [network] [--for duration]`."
+}
+
+func (OpHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermUsersManage}
+}
+
+func (h OpHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	return roleAuditTarget(h.networks, splitArgs(msg.CommandArguments()))
 }
 
 /* /deop handler */
-type DeopHandler struct{}
+type DeopHandler struct {
+	networks *Networks
+}
 
-func (DeopHandler) Handle(msg *tgbotapi.Message, _ *ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
-	if accessManager.GetAccessLevel(msg.Chat.ID) < AccessLevelAdmin {
-		return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-	}
+func (h DeopHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
+	return setRoleByArgs(msg, splitArgs(msg.CommandArguments()), accessManager, h.networks, RoleGuest)
+}
+
+func (DeopHandler) Description() string {
+	return "Makes user with given user_id (number) a guest in app or removes their role in given network. " +
+		"Usage:`/deop user_id [network]`."
+}
+
+func (DeopHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermUsersManage}
+}
+
+func (h DeopHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	return roleAuditTarget(h.networks, splitArgs(msg.CommandArguments()))
+}
+
+/* /role handler */
+type RoleHandler struct {
+	networks *Networks
+	roles    *Roles
+}
 
+func (h RoleHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, accessManager AccessManager) (tgbotapi.MessageConfig, error) {
 	args := splitArgs(msg.CommandArguments())
-	if len(args) == 0 {
-		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
+	if len(args) < 2 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Not enough arguments given. Try /help."), nil
 	}
-	if len(args) > 1 {
-		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
+	role := args[1]
+	if !h.roles.Assignable(role) {
+		return tgbotapi.NewMessage(msg.Chat.ID,
+			"Unknown role. Available roles: "+strings.Join(h.roles.Names(), ", ")+"."), nil
 	}
+	return setRoleByArgs(msg, append(args[:1:1], args[2:]...), accessManager, h.networks, role)
+}
 
-	id, err := strconv.ParseInt(args[0], 10, 64)
-	if err != nil {
-		return tgbotapi.NewMessage(msg.Chat.ID, "Invalid argument. Try /help."), nil
-	}
+func (RoleHandler) Description() string {
+	return "Gives user with given user_id (number) a role in app or only in given network. " +
+		"Roles are defined in config. Usage:`/role user_id role [network] [--for duration]`."
+}
 
-	err = accessManager.SetAccessLevel(id, AccessLevelGuest)
-	if err != nil {
-		if err == AdminMutationError {
-			return tgbotapi.NewMessage(msg.Chat.ID, AccessDeniedText), nil
-		}
-		return tgbotapi.MessageConfig{}, err
-	}
-	return tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Success. %d is a guest now.", id)), nil
+func (RoleHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermUsersManage}
 }
 
-func (DeopHandler) Description() string {
-	return "Makes user with given user_id (number) a guest in app. Usage:`/deop user_id`."
+func (h RoleHandler) AuditTarget(msg *tgbotapi.Message) (string, string, bool) {
+	args := splitArgs(msg.CommandArguments())
+	if len(args) > 1 {
+		// skip role
+		args = append(args[:1:1], args[2:]...)
+	}
+	return roleAuditTarget(h.networks, args)
 }
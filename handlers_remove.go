@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"time"
+)
+
+// Route of deletion confirm buttons, their args are network id and NodeID
+const removeCallbackRoute = "remove"
+
+// How long deletion can be confirmed after it has been asked for
+const removeConfirmationLifetime = 5 * time.Minute
+
+/* /remove handler */
+type RemoveHandler struct {
+	networks *Networks
+	expiry   *ExpiryScheduler
+	signer   *CallbackSigner
+}
+
+func (h RemoveHandler) Handle(_ context.Context, msg *tgbotapi.Message, _ ZeroTierApi, _ AccessManager) (tgbotapi.MessageConfig, error) {
+	args := splitArgs(msg.CommandArguments())
+	networkId, args, err := h.networks.FromArgs(msg.Chat.ID, args)
+	if err != nil {
+		return tgbotapi.NewMessage(msg.Chat.ID, networkErrorText(err)), nil
+	}
+	if len(args) == 0 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "No arguments given. Try /help."), nil
+	}
+	if len(args) > 1 {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Too many arguments given. Try /help."), nil
+	}
+	nodeId := args[0]
+	if err := validateIds(networkId, nodeId); err == InvalidNodeId {
+		return tgbotapi.NewMessage(msg.Chat.ID, "Invalid NodeID"), nil
+	}
+
+	// deletion can't be undone, so it is done only by press of the signed button of the user
+	confirm, err := h.signer.Button("Delete", msg.Chat.ID, removeConfirmationLifetime, removeCallbackRoute, networkId, nodeId)
+	if err != nil {
+		return tgbotapi.MessageConfig{}, err
+	}
+	rep := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Member %s will be deleted from %s. This can't be undone!\n"+
+		"Press the button within %s to confirm.", nodeId, h.networks.Name(networkId), removeConfirmationLifetime))
+	rep.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(confirm))
+	return rep, nil
+}
+
+func (RemoveHandler) Description() string {
+	return "Deletes given NodeID from network, asks for confirmation with a button first. Usage:`/remove [@network] NodeID`."
+}
+
+func (RemoveHandler) Requires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersRemove, NetworkScoped: true}
+}
+
+// HandleCallback deletes member by press of the confirm button
+func (h RemoveHandler) HandleCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string, ztApi ZeroTierApi, _ AccessManager) (CallbackReply, error) {
+	if len(args) != 2 {
+		return CallbackReply{Text: "Unknown button."}, nil
+	}
+	networkId, nodeId := args[0], args[1]
+	err := ztApi.DeleteMember(ctx, networkId, nodeId)
+	if err != nil {
+		if err == InvalidNodeId {
+			return CallbackReply{Text: "Invalid NodeID"}, nil
+		}
+		if IsNotFound(err) {
+			return CallbackReply{Text: fmt.Sprintf("Failed to remove %s: no such member in %s.", nodeId, h.networks.Name(networkId))}, nil
+		}
+		return CallbackReply{}, err
+	}
+	cancelExpiry(h.expiry, networkId, nodeId)
+	text := fmt.Sprintf("Success. %s has been removed from %s.", nodeId, h.networks.Name(networkId))
+	return CallbackReply{Text: text, EditText: text}, nil
+}
+
+func (RemoveHandler) CallbackRequires() AccessRequirement {
+	return AccessRequirement{Permission: PermMembersRemove, NetworkScoped: true}
+}
+
+// Only confirmed removal is audited, the command itself changes nothing
+func (RemoveHandler) CallbackAuditTarget(args []string) (string, string, bool) {
+	if len(args) != 2 {
+		return "", "", false
+	}
+	return args[0], args[1], true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// How long join request waits for approval
+	joinRequestLifetime = 24 * time.Hour
+	// How many pending requests one user may have
+	maxJoinRequestsPerUser = 3
+)
+
+var (
+	JoinRequestExists   = errors.New("join request for the node is already pending")
+	TooManyJoinRequests = errors.New("too many pending join requests")
+	JoinRequestNotFound = errors.New("join request not found or expired")
+)
+
+// JoinRequest is a guest's request to authorize a node, it waits for approval of an operator
+type JoinRequest struct {
+	Id            string    `json:"id"`
+	NetworkId     string    `json:"network_id"`
+	NodeId        string    `json:"node_id"`
+	Name          string    `json:"name,omitempty"`
+	RequesterId   int64     `json:"requester_id"`
+	RequesterName string    `json:"requester_name,omitempty"` // telegram username
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// JoinRequests keeps pending join requests in file, at most one per node in a network.
+// Expired requests are dropped. It is safe for concurrent use.
+type JoinRequests struct {
+	mu       sync.Mutex
+	filepath string
+	requests []JoinRequest
+}
+
+func NewJoinRequests(filepath string) (*JoinRequests, error) {
+	r := &JoinRequests{filepath: filepath}
+	fileData, err := ioutil.ReadFile(filepath)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(fileData, &r.requests); err != nil {
+		return nil, fmt.Errorf("join requests file %s: %w", filepath, err)
+	}
+	return r, nil
+}
+
+// Create adds request with new id. If request for the node is pending, it is returned with JoinRequestExists.
+func (r *JoinRequests) Create(req JoinRequest) (JoinRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired(time.Now())
+	userRequests := 0
+	for _, pending := range r.requests {
+		if pending.NetworkId == req.NetworkId && pending.NodeId == req.NodeId {
+			return pending, JoinRequestExists
+		}
+		if pending.RequesterId == req.RequesterId {
+			userRequests++
+		}
+	}
+	if userRequests >= maxJoinRequestsPerUser {
+		return JoinRequest{}, TooManyJoinRequests
+	}
+
+	// short, since it has to fit into callback data
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return JoinRequest{}, err
+	}
+	req.Id = hex.EncodeToString(id)
+	req.CreatedAt = time.Now().UTC()
+	req.ExpiresAt = req.CreatedAt.Add(joinRequestLifetime)
+	r.requests = append(r.requests, req)
+	return req, r.commit()
+}
+
+// Take removes pending request and returns it, so that it is decided only once
+func (r *JoinRequests) Take(id string) (JoinRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired(time.Now())
+	for i, req := range r.requests {
+		if req.Id == id {
+			r.requests = append(r.requests[:i], r.requests[i+1:]...)
+			return req, r.commit()
+		}
+	}
+	return JoinRequest{}, JoinRequestNotFound
+}
+
+// Restore puts back request taken by Take, e.g. when it has not been decided because of an error
+func (r *JoinRequests) Restore(req JoinRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropExpired(time.Now())
+	for _, pending := range r.requests {
+		if pending.NetworkId == req.NetworkId && pending.NodeId == req.NodeId {
+			return nil
+		}
+	}
+	if !req.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	r.requests = append(r.requests, req)
+	return r.commit()
+}
+
+// Get returns pending request without removing it
+func (r *JoinRequests) Get(id string) (JoinRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		if req.Id == id && req.ExpiresAt.After(time.Now()) {
+			return req, nil
+		}
+	}
+	return JoinRequest{}, JoinRequestNotFound
+}
+
+// dropExpired removes expired requests from memory, they are removed from file on next commit. r.mu must be locked.
+func (r *JoinRequests) dropExpired(now time.Time) {
+	pending := r.requests[:0]
+	for _, req := range r.requests {
+		if req.ExpiresAt.After(now) {
+			pending = append(pending, req)
+		}
+	}
+	r.requests = pending
+}
+
+// commit saves requests to file, r.mu must be locked
+func (r *JoinRequests) commit() error {
+	fileData, err := json.MarshalIndent(r.requests, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.filepath, fileData, 0644)
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
-	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"runtime/debug"
 	"strconv"
+	"syscall"
 )
 
 type WebhookConfigCustom struct {
@@ -19,14 +24,24 @@ type WebhookConfigCustom struct {
 }
 
 func SetWebhookCustom(bot *tgbotapi.BotAPI, config *WebhookConfigCustom) (tgbotapi.APIResponse, error) {
+	// telegram expects JSON array of update types
+	var allowedUpdates string
+	if len(config.AllowedUpdates) > 0 {
+		data, err := json.Marshal(config.AllowedUpdates)
+		if err != nil {
+			return tgbotapi.APIResponse{}, err
+		}
+		allowedUpdates = string(data)
+	}
+
 	if config.Certificate == nil {
 		v := url.Values{}
 		v.Add("url", config.URL.String())
 		if config.DropPendingUpdates {
 			v.Add("drop_pending_updates", "True")
 		}
-		if len(config.AllowedUpdates) > 0 {
-			v.Add("allowed_updates", fmt.Sprint(config.AllowedUpdates))
+		if len(allowedUpdates) > 0 {
+			v.Add("allowed_updates", allowedUpdates)
 		}
 		if config.MaxConnections != 0 {
 			v.Add("max_connections", strconv.Itoa(config.MaxConnections))
@@ -43,8 +58,8 @@ func SetWebhookCustom(bot *tgbotapi.BotAPI, config *WebhookConfigCustom) (tgbota
 	if config.DropPendingUpdates {
 		params["drop_pending_updates"] = "True"
 	}
-	if len(config.AllowedUpdates) > 0 {
-		params["allowed_updates"] = fmt.Sprint(config.AllowedUpdates)
+	if len(allowedUpdates) > 0 {
+		params["allowed_updates"] = allowedUpdates
 	}
 
 	resp, err := bot.UploadFile("setWebhook", params, "certificate", config.Certificate)
@@ -58,6 +73,7 @@ func SetWebhookCustom(bot *tgbotapi.BotAPI, config *WebhookConfigCustom) (tgbota
 func main() {
 	configFile := flag.String("config", "", "path to a config file")
 	debugMode := flag.Bool("debug", false, "run bot in debug mode")
+	importOps := flag.String("import-ops", "", "import roles from given ops file to SQLite database from config and exit")
 	flag.Parse()
 
 	botConfig, err := LoadConfig(*configFile)
@@ -65,14 +81,90 @@ func main() {
 		log.Fatalf("Error loading config: %s", err.Error())
 	}
 
-	accessManager, err := NewAccessManagerWithFileStorage(botConfig.AdminId, botConfig.OpsStorage)
+	roles, err := NewRoles(botConfig.Roles)
+	if err != nil {
+		log.Fatalf("Error loading roles: %s", err.Error())
+	}
+
+	if len(*importOps) > 0 {
+		if botConfig.OpsBackend != OpsBackendSQLite {
+			log.Fatalln("ops_backend must be sqlite to import ops file")
+		}
+		db, err := NewAccessManagerWithSQLite(botConfig.AdminId, roles, botConfig.OpsStorage)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		imported, err := db.ImportOpsFile(*importOps)
+		_ = db.Close()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Imported %d roles from %s to %s", imported, *importOps, botConfig.OpsStorage)
+		return
+	}
+
+	var accessManager AccessManager
+	switch botConfig.OpsBackend {
+	case "", OpsBackendFile:
+		accessManager, err = NewAccessManagerWithFileStorage(botConfig.AdminId, roles, botConfig.OpsStorage)
+	case OpsBackendSQLite:
+		accessManager, err = NewAccessManagerWithSQLite(botConfig.AdminId, roles, botConfig.OpsStorage)
+	default:
+		err = UnknownOpsBackend
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
+	defer accessManager.Close()
 
-	ztApi := NewZTApi(botConfig.ZeroTierToken, botConfig.ZeroTierNetwork)
+	ztApi, err := NewZTApi(ZTApiOptions{
+		Backend:    botConfig.ZeroTierBackend,
+		Token:      botConfig.ZeroTierToken,
+		BaseUrl:    botConfig.ZeroTierApiUrl,
+		Timeout:    botConfig.ZeroTierTimeout,
+		MaxRetries: botConfig.ZeroTierRetries,
+		RateLimit:  botConfig.ZeroTierRate,
+		Burst:      botConfig.ZeroTierBurst,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	commandManager := NewCommandManager(ztApi, accessManager)
+	networks, err := NewNetworks(botConfig.ZeroTierNetworks, botConfig.ZeroTierNetwork)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var auditLog AuditLog
+	if len(botConfig.AuditFile) > 0 {
+		auditLogFile, err := NewAuditLogFile(botConfig.AuditFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer auditLogFile.Close()
+		auditLog = auditLogFile
+	}
+
+	var expiry *ExpiryScheduler
+	if len(botConfig.ExpiryFile) > 0 {
+		expiry, err = NewExpiryScheduler(botConfig.ExpiryFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var joinRequests *JoinRequests
+	if len(botConfig.JoinFile) > 0 {
+		joinRequests, err = NewJoinRequests(botConfig.JoinFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	signer, err := NewCallbackSigner(botConfig.CallbackSecret)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	whURL, err := url.Parse(botConfig.WebHookUrl)
 	if err != nil {
@@ -89,11 +181,15 @@ func main() {
 		log.Println("Bot is running in DEBUG mode")
 	}
 
+	commandManager := NewCommandManager(ztApi, accessManager, networks, roles, auditLog, expiry, joinRequests, botSender(bot), signer)
+	commandManager.Use(RecoveryMiddleware, LoggingMiddleware)
+	commandManager.UseCallbacks(RecoveryCallbackMiddleware, LoggingCallbackMiddleware)
+
 	resp, err := SetWebhookCustom(bot, &WebhookConfigCustom{
 		URL:                whURL,
 		Certificate:        botConfig.WebHookCertFile,
 		DropPendingUpdates: true,
-		AllowedUpdates:     []string{"message"},
+		AllowedUpdates:     []string{"message", "callback_query"},
 	})
 	if err != nil {
 		log.Fatalln(err)
@@ -107,32 +203,120 @@ func main() {
 	go http.ListenAndServeTLS(botConfig.ListenAddr+":"+botConfig.ListenPort,
 		botConfig.WebHookCertFile, botConfig.WebHookKeyFile, nil)
 
-	for update := range updates {
-		if update.Message == nil { // ignore all non-message updates
-			continue
+	// cancelled on Ctrl+C, so that pending ZeroTier requests are aborted and webhook is removed
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Shutting down")
+		stop()
+	}()
+
+	if expiry != nil {
+		go expiry.Run(ctx, ztApi, networks, botNotifier(bot))
+	}
+	go RunGrantExpiry(ctx, accessManager, botConfig.AdminId, networks, botNotifier(bot))
+
+	workers := NewWorkerPool(botConfig.Workers, func(update tgbotapi.Update) {
+		handleUpdate(ctx, bot, commandManager, update, *debugMode)
+	})
+	defer workers.Stop()
+
+	for {
+		var update tgbotapi.Update
+		select {
+		case <-ctx.Done():
+			return
+		case update = <-updates:
+		}
+		if err := workers.Submit(ctx, update); err != nil {
+			return
+		}
+	}
+}
+
+// handleUpdate handles one update. Panics are recovered, so that the bot keeps serving other users.
+func handleUpdate(ctx context.Context, bot *tgbotapi.BotAPI, commandManager *CommandManager, update tgbotapi.Update, debugMode bool) {
+	if update.Message == nil && update.CallbackQuery == nil { // ignore all other updates
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("update %d: panic: %v\n%s", update.UpdateID, r, debug.Stack())
+			reportFailure(bot, update)
 		}
-		if update.Message.Chat.IsPrivate() {
-			if *debugMode {
-				log.Println("command:", update.Message.Command())
-				log.Println("args:", update.Message.CommandArguments())
-			}
-			rep, err := commandManager.HandleMessage(update.Message)
-			if err == nil {
-				_, err = bot.Send(rep)
-			} else {
-				log.Println(err)
-				errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong!")
-				_, err = bot.Send(errMsg)
-				if err != nil {
-					log.Println(err)
-				}
-			}
-		} else {
-			errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "I only work with private chats")
-			_, err = bot.Send(errMsg)
-			if err != nil {
-				log.Println(err)
-			}
+	}()
+
+	if update.CallbackQuery != nil {
+		handleCallback(ctx, bot, commandManager, update)
+		return
+	}
+
+	if !update.Message.Chat.IsPrivate() {
+		errMsg := tgbotapi.NewMessage(update.Message.Chat.ID, "I only work with private chats")
+		if _, err := bot.Send(errMsg); err != nil {
+			log.Printf("update %d: %s", update.UpdateID, err.Error())
 		}
+		return
+	}
+	if debugMode {
+		log.Println("command:", update.Message.Command())
+		log.Println("args:", update.Message.CommandArguments())
+	}
+	rep, err := commandManager.HandleMessage(ctx, update.Message)
+	if err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
+		reportFailure(bot, update)
+		return
+	}
+	if _, err = bot.Send(rep); err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
+	}
+}
+
+// handleCallback answers press of inline keyboard button and updates the message with it
+func handleCallback(ctx context.Context, bot *tgbotapi.BotAPI, commandManager *CommandManager, update tgbotapi.Update) {
+	query := update.CallbackQuery
+	rep, err := commandManager.HandleCallback(ctx, query)
+	if err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
+		rep = CallbackReply{Text: "Something went wrong!"}
+	}
+	// telegram shows progress on the button until the query is answered
+	if _, err = bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, rep.Text)); err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
+	}
+	if len(rep.EditText) == 0 || query.Message == nil {
+		return
+	}
+	if _, err = bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, rep.EditText)); err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
+	}
+}
+
+// botSender sends messages via bot
+func botSender(bot *tgbotapi.BotAPI) MessageSender {
+	return func(msg tgbotapi.MessageConfig) error {
+		_, err := bot.Send(msg)
+		return err
+	}
+}
+
+// botNotifier sends notifications via bot, failures are logged
+func botNotifier(bot *tgbotapi.BotAPI) Notifier {
+	return func(chatId int64, text string) {
+		if _, err := bot.Send(tgbotapi.NewMessage(chatId, text)); err != nil {
+			log.Printf("notification to %d: %s", chatId, err.Error())
+		}
+	}
+}
+
+// reportFailure tells user that their update has not been handled
+func reportFailure(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	errMsg := tgbotapi.NewMessage(updateChatId(update), "Something went wrong!")
+	if _, err := bot.Send(errMsg); err != nil {
+		log.Printf("update %d: %s", update.UpdateID, err.Error())
 	}
 }
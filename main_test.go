@@ -0,0 +1,57 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// telegramTransport answers every request of bot with success and saves its form
+type telegramTransport struct {
+	form url.Values
+}
+
+func (t *telegramTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		return nil, err
+	}
+	t.form = r.Form
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"ok":true,"result":true}`)),
+		Request:    r,
+	}, nil
+}
+
+func TestSetWebhookCustomSendsAllowedUpdatesAsJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		certificate interface{}
+	}{
+		{name: "without certificate"},
+		{name: "with certificate", certificate: tgbotapi.FileBytes{Name: "cert.pem", Bytes: []byte("certificate")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &telegramTransport{}
+			bot := &tgbotapi.BotAPI{Token: "token", Client: &http.Client{Transport: transport}}
+			whURL, _ := url.Parse("https://example.com/token")
+
+			_, err := SetWebhookCustom(bot, &WebhookConfigCustom{
+				URL:            whURL,
+				Certificate:    tt.certificate,
+				AllowedUpdates: []string{"message", "callback_query"},
+			})
+			if err != nil {
+				t.Fatalf("SetWebhookCustom() error = %v", err)
+			}
+			if got, want := transport.form.Get("allowed_updates"), `["message","callback_query"]`; got != want {
+				t.Errorf("allowed_updates = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Handler handles a message, e.g. runs a command
+type Handler func(ctx context.Context, msg *tgbotapi.Message) (tgbotapi.MessageConfig, error)
+
+// Middleware wraps Handler to add behavior common for all commands (logging, rate limiting, metrics etc.).
+// It may handle message itself without calling next, e.g. to reject it.
+type Middleware func(next Handler) Handler
+
+// chain wraps h in middlewares, the first of them being the outermost
+func chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// PanicError is returned by RecoveryMiddleware instead of a panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// RecoveryMiddleware turns panics of next handlers into PanicError, so that a bad command doesn't stop the bot
+func RecoveryMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg *tgbotapi.Message) (rep tgbotapi.MessageConfig, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+				log.Printf("panic while handling /%s from %d: %v\n%s", msg.Command(), msg.Chat.ID, r, err.(*PanicError).Stack)
+			}
+		}()
+		return next(ctx, msg)
+	}
+}
+
+// LoggingMiddleware logs every command with its sender, duration and error if any.
+// Command arguments are not logged.
+func LoggingMiddleware(next Handler) Handler {
+	return func(ctx context.Context, msg *tgbotapi.Message) (tgbotapi.MessageConfig, error) {
+		start := time.Now()
+		rep, err := next(ctx, msg)
+		if err != nil {
+			log.Printf("/%s from %d failed in %s: %s", msg.Command(), msg.Chat.ID, time.Since(start), err.Error())
+		} else {
+			log.Printf("/%s from %d handled in %s", msg.Command(), msg.Chat.ID, time.Since(start))
+		}
+		return rep, err
+	}
+}
+
+// CallbackFunc handles a press of a button
+type CallbackFunc func(ctx context.Context, query *tgbotapi.CallbackQuery) (CallbackReply, error)
+
+// CallbackMiddleware is Middleware for presses of buttons, they are not messages and have replies of their own
+type CallbackMiddleware func(next CallbackFunc) CallbackFunc
+
+// chainCallbacks wraps f in middlewares, the first of them being the outermost
+func chainCallbacks(f CallbackFunc, middlewares ...CallbackMiddleware) CallbackFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](f)
+	}
+	return f
+}
+
+// callbackRoute returns route of button from its data, the data is not verified
+func callbackRoute(query *tgbotapi.CallbackQuery) string {
+	return strings.SplitN(query.Data, ":", 2)[0]
+}
+
+// RecoveryCallbackMiddleware is RecoveryMiddleware for presses of buttons
+func RecoveryCallbackMiddleware(next CallbackFunc) CallbackFunc {
+	return func(ctx context.Context, query *tgbotapi.CallbackQuery) (rep CallbackReply, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+				log.Printf("panic while handling button %q from %d: %v\n%s", callbackRoute(query), query.From.ID, r, err.(*PanicError).Stack)
+			}
+		}()
+		return next(ctx, query)
+	}
+}
+
+// LoggingCallbackMiddleware is LoggingMiddleware for presses of buttons.
+// Only route of the button is logged, not its arguments.
+func LoggingCallbackMiddleware(next CallbackFunc) CallbackFunc {
+	return func(ctx context.Context, query *tgbotapi.CallbackQuery) (CallbackReply, error) {
+		start := time.Now()
+		rep, err := next(ctx, query)
+		if err != nil {
+			log.Printf("button %q from %d failed in %s: %s", callbackRoute(query), query.From.ID, time.Since(start), err.Error())
+		} else {
+			log.Printf("button %q from %d handled in %s", callbackRoute(query), query.From.ID, time.Since(start))
+		}
+		return rep, err
+	}
+}
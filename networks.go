@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Prefix of network argument in commands, e.g. `/auth @lab NodeID`
+const networkArgPrefix = "@"
+
+var (
+	UnknownNetwork    = errors.New("unknown network")
+	NoNetworkSelected = errors.New("no network selected")
+)
+
+// Networks knows which ZeroTier networks the bot manages and resolves network references given by users:
+// aliases (`@lab`), network ids and per-user current network.
+// Users' current networks are kept in memory only, after restart everyone is back on the default network.
+type Networks struct {
+	mu             sync.Mutex
+	aliases        map[string]string // alias -> network id
+	names          map[string]string // network id -> alias
+	defaultNetwork string            // may be empty, then users have to select network
+	current        map[int64]string  // user id -> network id
+}
+
+// NewNetworks validates configured networks. defaultNetwork may be either an alias or a network id;
+// if it's empty and there is only one alias, that network becomes default.
+func NewNetworks(aliases map[string]string, defaultNetwork string) (*Networks, error) {
+	n := &Networks{
+		aliases: make(map[string]string, len(aliases)),
+		names:   make(map[string]string, len(aliases)),
+		current: make(map[int64]string),
+	}
+	for alias, networkId := range aliases {
+		alias = strings.TrimPrefix(alias, networkArgPrefix)
+		if len(alias) == 0 || strings.ContainsAny(alias, " @") {
+			return nil, fmt.Errorf("invalid network alias %q", alias)
+		}
+		if !networkIdRegEx.MatchString(networkId) {
+			return nil, fmt.Errorf("network %q: %w", alias, InvalidNetworkId)
+		}
+		n.aliases[alias] = networkId
+		n.names[networkId] = alias
+	}
+
+	if id, found := n.aliases[strings.TrimPrefix(defaultNetwork, networkArgPrefix)]; found {
+		n.defaultNetwork = id
+	} else if len(defaultNetwork) > 0 {
+		if !networkIdRegEx.MatchString(defaultNetwork) {
+			return nil, fmt.Errorf("default network: %w", InvalidNetworkId)
+		}
+		n.defaultNetwork = defaultNetwork
+	} else if len(n.aliases) == 1 {
+		for _, id := range n.aliases {
+			n.defaultNetwork = id
+		}
+	}
+	return n, nil
+}
+
+// Resolve turns `@alias`, `alias` or id of a managed network into network id
+func (n *Networks) Resolve(ref string) (string, error) {
+	ref = strings.TrimPrefix(ref, networkArgPrefix)
+	if id, found := n.aliases[ref]; found {
+		return id, nil
+	}
+	if _, found := n.names[ref]; found || (ref == n.defaultNetwork && len(ref) > 0) {
+		return ref, nil
+	}
+	return "", UnknownNetwork
+}
+
+// Current returns network user acts on when no network is given explicitly
+func (n *Networks) Current(userId int64) (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if id, found := n.current[userId]; found {
+		return id, nil
+	}
+	if len(n.defaultNetwork) == 0 {
+		return "", NoNetworkSelected
+	}
+	return n.defaultNetwork, nil
+}
+
+// SetCurrent makes ref user's current network
+func (n *Networks) SetCurrent(userId int64, ref string) (string, error) {
+	id, err := n.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.current[userId] = id
+	return id, nil
+}
+
+// FromArgs pops optional `@network` first argument and returns network to act on and the rest of arguments
+func (n *Networks) FromArgs(userId int64, args []string) (string, []string, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], networkArgPrefix) {
+		id, err := n.Resolve(args[0])
+		return id, args[1:], err
+	}
+	id, err := n.Current(userId)
+	return id, args, err
+}
+
+// Name returns human-readable name of network: `alias (id)` or just id
+func (n *Networks) Name(networkId string) string {
+	if alias, found := n.names[networkId]; found {
+		return fmt.Sprintf("%s (%s)", alias, networkId)
+	}
+	return networkId
+}
+
+// List returns ids of all managed networks sorted by name
+func (n *Networks) List() []string {
+	ids := make([]string, 0, len(n.names)+1)
+	for id := range n.names {
+		ids = append(ids, id)
+	}
+	if _, found := n.names[n.defaultNetwork]; !found && len(n.defaultNetwork) > 0 {
+		ids = append(ids, n.defaultNetwork)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return n.Name(ids[i]) < n.Name(ids[j])
+	})
+	return ids
+}
+
+// networkErrorText explains errors of Networks methods to user
+func networkErrorText(err error) string {
+	if err == NoNetworkSelected {
+		return "No network selected. Choose one with /use or give it as the first argument, e.g. `@name`."
+	}
+	return "Unknown network. Try /use to see available networks."
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Permission allows user to do one kind of actions. Commands check permissions instead of comparing access levels.
+type Permission string
+
+const (
+	PermMembersList   Permission = "members.list"
+	PermMembersAuth   Permission = "members.auth"
+	PermMembersUnauth Permission = "members.unauth"
+	PermMembersEdit   Permission = "members.edit"
+	PermMembersRemove Permission = "members.remove"
+	PermNetworkView   Permission = "network.view"
+	PermNetworkEdit   Permission = "network.edit"
+	PermUsersManage   Permission = "users.manage"
+	PermAuditView     Permission = "audit.view"
+)
+
+var AllPermissions = []Permission{
+	PermMembersList, PermMembersAuth, PermMembersUnauth, PermMembersEdit, PermMembersRemove,
+	PermNetworkView, PermNetworkEdit,
+	PermUsersManage,
+	PermAuditView,
+}
+
+// Built-in roles. Banned users can't do anything at all, even use commands without permissions.
+// Guest is the role of everyone who has no other role.
+// Note: Due to app design, there must be only one user with RoleAdmin,
+// and it should be impossible to change them from the app runtime
+const (
+	RoleBanned   = "banned"
+	RoleGuest    = "guest"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+var InvalidRoleError = errors.New("invalid role")
+
+// Roles maps role names to permissions. Built-in roles may be redefined in config, except admin and banned.
+type Roles struct {
+	roles map[string]map[Permission]bool
+}
+
+// NewRoles creates built-in roles and roles from config. Permission `group.*` stands for all permissions of the group.
+func NewRoles(custom map[string][]string) (*Roles, error) {
+	r := &Roles{
+		roles: map[string]map[Permission]bool{
+			RoleBanned: {},
+			RoleGuest:  {},
+			RoleOperator: permissionSet(
+				PermMembersList, PermMembersAuth, PermMembersUnauth, PermMembersEdit, PermMembersRemove,
+				PermNetworkView),
+			RoleAdmin: permissionSet(AllPermissions...),
+		},
+	}
+	for name, permissions := range custom {
+		if name == RoleAdmin || name == RoleBanned {
+			return nil, fmt.Errorf("role %q can't be redefined", name)
+		}
+		if len(name) == 0 || strings.ContainsAny(name, " @") {
+			return nil, fmt.Errorf("invalid role name %q", name)
+		}
+		set := make(map[Permission]bool)
+		for _, p := range permissions {
+			expanded, err := expandPermission(p)
+			if err != nil {
+				return nil, fmt.Errorf("role %q: %w", name, err)
+			}
+			for _, e := range expanded {
+				set[e] = true
+			}
+		}
+		r.roles[name] = set
+	}
+	return r, nil
+}
+
+func permissionSet(permissions ...Permission) map[Permission]bool {
+	set := make(map[Permission]bool, len(permissions))
+	for _, p := range permissions {
+		set[p] = true
+	}
+	return set
+}
+
+func expandPermission(p string) ([]Permission, error) {
+	var expanded []Permission
+	for _, known := range AllPermissions {
+		if string(known) == p || (strings.HasSuffix(p, ".*") && strings.HasPrefix(string(known), strings.TrimSuffix(p, "*"))) {
+			expanded = append(expanded, known)
+		}
+	}
+	if len(expanded) == 0 {
+		return nil, fmt.Errorf("unknown permission %q", p)
+	}
+	return expanded, nil
+}
+
+// Has tells if role grants permission. Unknown roles grant nothing.
+func (r *Roles) Has(role string, permission Permission) bool {
+	return r.roles[role][permission]
+}
+
+// HasAny tells if role grants at least one permission
+func (r *Roles) HasAny(role string) bool {
+	return len(r.roles[role]) > 0
+}
+
+func (r *Roles) Exists(role string) bool {
+	_, found := r.roles[role]
+	return found
+}
+
+// Assignable tells if role can be given to user from the app runtime
+func (r *Roles) Assignable(role string) bool {
+	return r.Exists(role) && role != RoleAdmin
+}
+
+// Permissions returns permissions of role sorted
+func (r *Roles) Permissions(role string) []Permission {
+	permissions := make([]Permission, 0, len(r.roles[role]))
+	for p := range r.roles[role] {
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+	return permissions
+}
+
+// Names returns names of all assignable roles sorted
+func (r *Roles) Names() []string {
+	names := make([]string, 0, len(r.roles))
+	for name := range r.roles {
+		if r.Assignable(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
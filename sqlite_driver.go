@@ -0,0 +1,4 @@
+package main
+
+// Pure-Go SQLite driver for AccessManagerWithSQLite, registered as "sqlite"
+import _ "modernc.org/sqlite"
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sync"
+)
+
+const defaultWorkers = 4
+
+// How many updates per worker may wait for handling before Submit blocks
+const workerQueueSize = 16
+
+// WorkerPool handles updates concurrently by a fixed number of workers.
+// Updates from the same chat are handled one by one in order they were submitted,
+// a slow command delays only the chat it came from.
+type WorkerPool struct {
+	handle  func(tgbotapi.Update)
+	mu      sync.Mutex
+	pending map[int64][]tgbotapi.Update // chat id -> updates waiting for a worker which has taken the chat
+	ready   chan int64                  // chats with updates that no worker has taken yet
+	slots   chan struct{}               // limits updates submitted but not handled yet
+	wg      sync.WaitGroup
+}
+
+// NewWorkerPool starts workers; defaultWorkers are started if workers is not positive
+func NewWorkerPool(workers int, handle func(tgbotapi.Update)) *WorkerPool {
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	p := &WorkerPool{
+		handle:  handle,
+		pending: make(map[int64][]tgbotapi.Update),
+		ready:   make(chan int64, workers*workerQueueSize),
+		slots:   make(chan struct{}, workers*workerQueueSize),
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues update for handling. It blocks while the queue is full, unless ctx is done.
+func (p *WorkerPool) Submit(ctx context.Context, update tgbotapi.Update) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	chatId := updateChatId(update)
+	p.mu.Lock()
+	if queue, taken := p.pending[chatId]; taken {
+		p.pending[chatId] = append(queue, update)
+		p.mu.Unlock()
+		return nil
+	}
+	p.pending[chatId] = []tgbotapi.Update{update}
+	p.mu.Unlock()
+	// never blocks: there are no more ready chats than slots
+	p.ready <- chatId
+	return nil
+}
+
+// Stop waits until all submitted updates are handled and stops workers. Submit must not be called after Stop.
+func (p *WorkerPool) Stop() {
+	close(p.ready)
+	p.wg.Wait()
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for chatId := range p.ready {
+		for {
+			p.mu.Lock()
+			queue := p.pending[chatId]
+			if len(queue) == 0 {
+				// let other workers take the chat again
+				delete(p.pending, chatId)
+				p.mu.Unlock()
+				break
+			}
+			update := queue[0]
+			p.pending[chatId] = queue[1:]
+			p.mu.Unlock()
+
+			p.handle(update)
+			<-p.slots
+		}
+	}
+}
+
+// updateChatId returns id of chat the update came from, 0 for updates without chat
+func updateChatId(update tgbotapi.Update) int64 {
+	if update.Message != nil && update.Message.Chat != nil {
+		return update.Message.Chat.ID
+	}
+	// buttons are sent to private chats only, where chat id is user id
+	if update.CallbackQuery != nil && update.CallbackQuery.From != nil {
+		return int64(update.CallbackQuery.From.ID)
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolKeepsChatOrder(t *testing.T) {
+	const chats, updatesPerChat = 5, 100
+	var mu sync.Mutex
+	handled := make(map[int64][]int) // chat id -> ids of its handled updates
+	pool := NewWorkerPool(3, func(update tgbotapi.Update) {
+		chatId := updateChatId(update)
+		if update.UpdateID%7 == 0 {
+			// a slow command must not let later updates of its chat overtake it
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		handled[chatId] = append(handled[chatId], update.UpdateID)
+		mu.Unlock()
+	})
+
+	for i := 0; i < chats*updatesPerChat; i++ {
+		chatId := int64(i%chats + 1)
+		update := tgbotapi.Update{UpdateID: i}
+		if i%2 == 0 {
+			update.Message = &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatId}}
+		} else {
+			update.CallbackQuery = &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: int(chatId)}}
+		}
+		if err := pool.Submit(context.Background(), update); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+	pool.Stop()
+
+	if len(handled) != chats {
+		t.Fatalf("updates of %d chats handled, want %d", len(handled), chats)
+	}
+	for chatId, ids := range handled {
+		if len(ids) != updatesPerChat {
+			t.Errorf("chat %d: %d updates handled, want %d", chatId, len(ids), updatesPerChat)
+		}
+		for i, id := range ids {
+			if want := i*chats + int(chatId) - 1; id != want {
+				t.Errorf("chat %d: update %d handled at position %d, want %d", chatId, id, i, want)
+				break
+			}
+		}
+	}
+}
+
+func TestWorkerPoolSubmitStopsWithContext(t *testing.T) {
+	release := make(chan struct{})
+	pool := NewWorkerPool(1, func(tgbotapi.Update) { <-release })
+	defer pool.Stop()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	update := tgbotapi.Update{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1}}}
+	var err error
+	// the only worker has room for workerQueueSize updates, the one being handled included
+	for i := 0; i <= workerQueueSize+1 && err == nil; i++ {
+		err = pool.Submit(ctx, update)
+	}
+	if err != context.DeadlineExceeded {
+		t.Errorf("Submit() to full queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -1,174 +1,179 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
-const zeroTierApiUrl = "https://my.zerotier.com/api"
+// Used when ZTApiOptions.Timeout is not set
+const defaultZTTimeout = 10 * time.Second
+
+// Supported ZeroTier backends (`zt_backend` in config)
+const (
+	ZTBackendCentral = "central" // ZeroTier Central (my.zerotier.com or compatible)
+	ZTBackendLocal   = "local"   // self-hosted controller via zerotier-one local service API
+)
 
 var (
 	InvalidNodeId    = errors.New("invalid NodeID format")
 	InvalidNetworkId = errors.New("invalid NetworkID format")
+	UnknownZTBackend = errors.New("unknown ZeroTier backend")
+	nodeIdRegEx      = regexp.MustCompile("^[0-9a-f]{10}$")
+	networkIdRegEx   = regexp.MustCompile("^[0-9a-f]{16}$")
 )
 
-// This struct is not complete yet
-type MemberConfigEditablePart struct {
-	Authorized    bool     `json:"authorized"`
-	IpAssignments []string `json:"ipAssignments"`
+// ZeroTierApi manages members of ZeroTier networks.
+// Look at `zerotierapi_*.go` for implementations.
+// Every request to ZeroTier is limited by both ctx and the timeout from ZTApiOptions.
+// Errors returned by ZeroTier itself are *APIError (look at IsNotFound and others).
+// Nil fields of update patches are omitted from requests, so ZeroTier leaves them as they are;
+// backends ignore fields they don't support.
+type ZeroTierApi interface {
+	AuthMember(ctx context.Context, networkId string, nodeId string, shortName string, description string) error
+	UnauthMemberByID(ctx context.Context, networkId string, nodeId string) error
+	ListMembers(ctx context.Context, networkId string) ([]*MemberInfo, error)
+	// UpdateMember changes only non-nil fields of patch and returns updated member
+	UpdateMember(ctx context.Context, networkId string, nodeId string, patch *MemberUpdate) (*MemberInfo, error)
+	// DeleteMember removes member's record from network. Node can join again, but has to be authorized anew.
+	DeleteMember(ctx context.Context, networkId string, nodeId string) error
+	GetNetwork(ctx context.Context, networkId string) (*NetworkInfo, error)
+	// UpdateNetwork changes only non-nil fields of patch and returns updated network
+	UpdateNetwork(ctx context.Context, networkId string, patch *NetworkUpdate) (*NetworkInfo, error)
 }
 
-// This struct is not complete yet
-type MemberEditablePart struct {
-	Hidden      bool                     `json:"hidden"`
-	Name        string                   `json:"name,omitempty"`
-	Description string                   `json:"description,omitempty"`
-	Config      MemberConfigEditablePart `json:"config,omitempty"`
+// ZTApiOptions holds everything needed to construct ZeroTierApi.
+// Zero values of Backend, BaseUrl, HttpClient and Timeout mean ZeroTier Central,
+// backend's default url, http.DefaultClient and defaultZTTimeout respectively.
+// Zero values of retry and rate limit settings mean defaults from `zerotierapi_retry.go`.
+type ZTApiOptions struct {
+	Backend    string
+	Token      string
+	BaseUrl    string
+	HttpClient *http.Client
+	Timeout    time.Duration // limits every request, its retries included
+	MaxRetries int           // negative value disables retries
+	RateLimit  float64       // requests per second, Central only
+	Burst      int           // requests allowed at once before RateLimit applies, Central only
 }
 
-// This struct is not complete yet
-type MemberInfo struct {
-	MemberEditablePart
-	NodeID          string `json:"nodeId"`
-	Online          bool   `json:"online"`
-	PhysicalAddress string `json:"physicalAddress"`
-	ClientVersion   string `json:"clientVersion"`
-}
-
-type ZeroTierApi struct {
-	accessToken    string
-	defaultNetwork string
-
-	nodeIdRegEx    *regexp.Regexp
-	networkIdRegEx *regexp.Regexp
-}
-
-func NewZTApi(token string, defaultNetwork string) *ZeroTierApi {
-	return &ZeroTierApi{
-		accessToken:    token,
-		defaultNetwork: defaultNetwork,
-		nodeIdRegEx:    regexp.MustCompile("^[0-9a-f]{10}$"),
-		networkIdRegEx: regexp.MustCompile("^[0-9a-f]{16}$"),
+func (o ZTApiOptions) timeoutOrDefault() time.Duration {
+	if o.Timeout <= 0 {
+		return defaultZTTimeout
 	}
+	return o.Timeout
 }
 
-func (api *ZeroTierApi) AuthMember(networkId string, nodeId string, shortName string, description string) (bool, error) {
-	if !api.networkIdRegEx.MatchString(networkId) {
-		return false, InvalidNetworkId
-	}
-	if !api.nodeIdRegEx.MatchString(nodeId) {
-		return false, InvalidNodeId
-	}
-	memberChanges := &MemberEditablePart{
-		Hidden:      false,
-		Name:        shortName,
-		Description: description,
-		Config: MemberConfigEditablePart{
-			Authorized: true,
-		},
-	}
-	jsonBytes, err := json.Marshal(memberChanges)
-	if err != nil {
-		return false, err
-	}
-
-	req, err := http.NewRequest("POST",
-		zeroTierApiUrl+fmt.Sprintf("/network/%s/member/%s", networkId, nodeId),
-		strings.NewReader(string(jsonBytes)))
-	if err != nil {
-		return false, err
+// NewZTApi creates ZeroTierApi implementation for options.Backend.
+// Requests of the implementation are rate limited and retried (look at retryTransport).
+func NewZTApi(options ZTApiOptions) (ZeroTierApi, error) {
+	options.HttpClient = withRetries(options.HttpClient, options)
+	switch options.Backend {
+	case "", ZTBackendCentral:
+		return NewCentralApi(options), nil
+	case ZTBackendLocal:
+		return NewLocalControllerApi(options), nil
+	default:
+		return nil, UnknownZTBackend
 	}
+}
 
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "bearer "+api.accessToken)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false, err
+func validateIds(networkId string, nodeId string) error {
+	if !networkIdRegEx.MatchString(networkId) {
+		return InvalidNetworkId
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("ZeroTierApi.AuthMember: Failed to auth user %s in network %s (status %s)\n", nodeId, networkId, resp.Status)
+	if !nodeIdRegEx.MatchString(nodeId) {
+		return InvalidNodeId
 	}
+	return nil
+}
 
-	return resp.StatusCode == http.StatusOK, nil
+// ztClient sends requests to ZeroTier HTTP API. Backends embed it, they differ only in endpoints
+// and in how requests are authorized.
+type ztClient struct {
+	baseUrl    string
+	httpClient *http.Client
+	timeout    time.Duration
+	authorize  func(header http.Header)
 }
 
-func (api *ZeroTierApi) UnauthMemberByID(networkId string, nodeId string) (bool, error) {
-	if !api.networkIdRegEx.MatchString(networkId) {
-		return false, InvalidNetworkId
-	}
-	if !api.nodeIdRegEx.MatchString(nodeId) {
-		return false, InvalidNodeId
+func newZTClient(options ZTApiOptions, defaultUrl string, authorize func(header http.Header)) ztClient {
+	baseUrl := options.BaseUrl
+	if len(baseUrl) == 0 {
+		baseUrl = defaultUrl
 	}
-	memberChanges := &MemberEditablePart{
-		Hidden: false,
-		Config: MemberConfigEditablePart{
-			Authorized: false,
-		},
+	httpClient := options.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
 	}
-	jsonBytes, err := json.Marshal(memberChanges)
-	if err != nil {
-		return false, err
+	return ztClient{
+		baseUrl:    strings.TrimRight(baseUrl, "/"),
+		httpClient: httpClient,
+		timeout:    options.timeoutOrDefault(),
+		authorize:  authorize,
 	}
+}
 
-	req, err := http.NewRequest("POST",
-		zeroTierApiUrl+fmt.Sprintf("/network/%s/member/%s", networkId, nodeId),
-		strings.NewReader(string(jsonBytes)))
-	if err != nil {
-		return false, err
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "bearer "+api.accessToken)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false, err
+// network sends request to endpoint made of format and networkId, see do
+func (c *ztClient) network(ctx context.Context, method string, format string, networkId string, body interface{}, result interface{}) error {
+	if !networkIdRegEx.MatchString(networkId) {
+		return InvalidNetworkId
 	}
+	return c.do(ctx, method, fmt.Sprintf(format, networkId), body, result)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("ZeroTierApi.UnauthMember: Failed to unauth user %s in network %s (status %s)\n", nodeId, networkId, resp.Status)
+// member sends request to endpoint made of format, networkId and nodeId, see do
+func (c *ztClient) member(ctx context.Context, method string, format string, networkId string, nodeId string, body interface{}, result interface{}) error {
+	if err := validateIds(networkId, nodeId); err != nil {
+		return err
 	}
-
-	return resp.StatusCode == http.StatusOK, nil
+	return c.do(ctx, method, fmt.Sprintf(format, networkId, nodeId), body, result)
 }
 
-func (api *ZeroTierApi) ListMembers(networkId string) ([]*MemberInfo, error) {
-	if !api.networkIdRegEx.MatchString(networkId) {
-		return nil, InvalidNetworkId
+// do sends body (if not nil) as JSON and decodes response into result (if not nil).
+// Non-2xx responses are returned as *APIError.
+func (c *ztClient) do(ctx context.Context, method string, endpoint string, body interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var reqBody io.Reader
+	if body != nil {
+		jsonBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(jsonBytes)
 	}
-	req, err := http.NewRequest("GET",
-		zeroTierApiUrl+fmt.Sprintf("/network/%s/member", networkId),
-		nil)
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+endpoint, reqBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	req.Header.Add("Authorization", "bearer "+api.accessToken)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	c.authorize(req.Header)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer resp.Body.Close()
 
-	members := make([]*MemberInfo, 0)
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("ZeroTierApi.ListMembers: Failed to get list of members of network %s (status %s)\n", networkId, resp.Status)
-		return nil, nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp, method, endpoint)
 	}
 
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&members)
-	if err != nil {
-		return nil, err
+	if result == nil {
+		return nil
 	}
-
-	return members, nil
+	return json.NewDecoder(resp.Body).Decode(result)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+const defaultZeroTierApiUrl = "https://my.zerotier.com/api"
+
+// CentralApi is ZeroTierApi implementation for ZeroTier Central API
+type CentralApi struct {
+	ztClient
+}
+
+func NewCentralApi(options ZTApiOptions) *CentralApi {
+	return &CentralApi{newZTClient(options, defaultZeroTierApiUrl, func(header http.Header) {
+		header.Add("Authorization", "bearer "+options.Token)
+	})}
+}
+
+func (api *CentralApi) AuthMember(ctx context.Context, networkId string, nodeId string, shortName string, description string) error {
+	_, err := api.UpdateMember(ctx, networkId, nodeId, authMemberUpdate(shortName, description))
+	return err
+}
+
+func (api *CentralApi) UnauthMemberByID(ctx context.Context, networkId string, nodeId string) error {
+	_, err := api.UpdateMember(ctx, networkId, nodeId, unauthMemberUpdate())
+	return err
+}
+
+func (api *CentralApi) UpdateMember(ctx context.Context, networkId string, nodeId string, patch *MemberUpdate) (*MemberInfo, error) {
+	member := &MemberInfo{}
+	err := api.member(ctx, "POST", "/network/%s/member/%s", networkId, nodeId, patch, member)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (api *CentralApi) DeleteMember(ctx context.Context, networkId string, nodeId string) error {
+	return api.member(ctx, "DELETE", "/network/%s/member/%s", networkId, nodeId, nil, nil)
+}
+
+func (api *CentralApi) ListMembers(ctx context.Context, networkId string) ([]*MemberInfo, error) {
+	members := make([]*MemberInfo, 0)
+	err := api.network(ctx, "GET", "/network/%s/member", networkId, nil, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (api *CentralApi) GetNetwork(ctx context.Context, networkId string) (*NetworkInfo, error) {
+	network := &NetworkInfo{}
+	err := api.network(ctx, "GET", "/network/%s", networkId, nil, network)
+	if err != nil {
+		return nil, err
+	}
+
+	return network, nil
+}
+
+func (api *CentralApi) UpdateNetwork(ctx context.Context, networkId string, patch *NetworkUpdate) (*NetworkInfo, error) {
+	network := &NetworkInfo{}
+	err := api.network(ctx, "POST", "/network/%s", networkId, patch, network)
+	if err != nil {
+		return nil, err
+	}
+
+	return network, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Longest error message taken from response body
+const maxAPIErrorMessageLen = 256
+
+// APIError is returned by ZeroTierApi calls when ZeroTier responds with non-2xx status
+type APIError struct {
+	StatusCode int
+	Method     string
+	Endpoint   string // request path relative to API base url
+	Message    string // error message from response body, may be empty
+}
+
+func (e *APIError) Error() string {
+	if len(e.Message) == 0 {
+		return fmt.Sprintf("ZeroTier API %s %s: status %d", e.Method, e.Endpoint, e.StatusCode)
+	}
+	return fmt.Sprintf("ZeroTier API %s %s: status %d: %s", e.Method, e.Endpoint, e.StatusCode, e.Message)
+}
+
+// newAPIError reads error message from resp's body. Body is not closed.
+func newAPIError(resp *http.Response, method string, endpoint string) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Endpoint:   endpoint,
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil || len(body) == 0 {
+		return apiErr
+	}
+	// Central and controller use either {"message": "..."} or {"error": "..."} or plain text
+	var parsed struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		apiErr.Message = parsed.Message
+		if len(apiErr.Message) == 0 {
+			apiErr.Message = parsed.Error
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	if len(apiErr.Message) > maxAPIErrorMessageLen {
+		apiErr.Message = apiErr.Message[:maxAPIErrorMessageLen] + "..."
+	}
+	return apiErr
+}
+
+func apiErrorStatus(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound tells if ZeroTier has no requested network or member
+func IsNotFound(err error) bool {
+	return apiErrorStatus(err) == http.StatusNotFound
+}
+
+// IsUnauthorized tells if ZeroTier has rejected bot's token
+func IsUnauthorized(err error) bool {
+	status := apiErrorStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// IsRateLimited tells if ZeroTier has refused the request because of rate limit, even after retries
+func IsRateLimited(err error) bool {
+	var unavailable *ZTUnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable.IsRateLimited()
+	}
+	return apiErrorStatus(err) == http.StatusTooManyRequests
+}
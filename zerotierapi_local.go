@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const defaultLocalControllerUrl = "http://localhost:9993"
+
+// Member as zerotier-one controller returns it: the same fields as in MemberConfig,
+// plus a few ones from MemberInfo. Everything Central-specific is absent.
+type localControllerMember struct {
+	MemberConfig
+	Address string `json:"address"`
+	Nwid    string `json:"nwid"`
+	Name    string `json:"name"`
+}
+
+func (m *localControllerMember) toMemberInfo() *MemberInfo {
+	info := &MemberInfo{
+		ID:        m.Nwid + "-" + m.Address,
+		NetworkID: m.Nwid,
+		NodeID:    m.Address,
+		Name:      m.Name,
+		Config:    m.MemberConfig,
+	}
+	// controller reports -1 when version is unknown
+	if m.VMajor >= 0 && m.VMinor >= 0 && m.VRev >= 0 {
+		info.ClientVersion = fmt.Sprintf("%d.%d.%d", m.VMajor, m.VMinor, m.VRev)
+		info.ProtocolVersion = m.VProto
+	}
+	return info
+}
+
+// Controller's member is flat, so config changes are sent at the top level
+type localControllerMemberUpdate struct {
+	*MemberConfigUpdate
+	Name *string `json:"name,omitempty"`
+}
+
+// Network as zerotier-one controller returns it: the same fields as in NetworkConfig
+type localControllerNetwork struct {
+	NetworkConfig
+	Nwid string `json:"nwid"`
+}
+
+func (n *localControllerNetwork) toNetworkInfo() *NetworkInfo {
+	return &NetworkInfo{
+		ID:     n.Nwid,
+		Config: n.NetworkConfig,
+	}
+}
+
+// LocalControllerApi is ZeroTierApi implementation for a self-hosted controller
+// reachable via zerotier-one local service API (usually on port 9993).
+// The token is the content of controller's authtoken.secret.
+type LocalControllerApi struct {
+	ztClient
+}
+
+func NewLocalControllerApi(options ZTApiOptions) *LocalControllerApi {
+	return &LocalControllerApi{newZTClient(options, defaultLocalControllerUrl, func(header http.Header) {
+		header.Add("X-ZT1-Auth", options.Token)
+	})}
+}
+
+// AuthMember authorizes member. Controller has no member descriptions, so description is dropped.
+func (api *LocalControllerApi) AuthMember(ctx context.Context, networkId string, nodeId string, shortName string, description string) error {
+	_, err := api.UpdateMember(ctx, networkId, nodeId, authMemberUpdate(shortName, description))
+	return err
+}
+
+func (api *LocalControllerApi) UnauthMemberByID(ctx context.Context, networkId string, nodeId string) error {
+	_, err := api.UpdateMember(ctx, networkId, nodeId, unauthMemberUpdate())
+	return err
+}
+
+// UpdateMember changes member. Controller has neither hidden flag nor description, so they are dropped.
+func (api *LocalControllerApi) UpdateMember(ctx context.Context, networkId string, nodeId string, patch *MemberUpdate) (*MemberInfo, error) {
+	changes := &localControllerMemberUpdate{
+		MemberConfigUpdate: patch.Config,
+		Name:               patch.Name,
+	}
+	var member localControllerMember
+	err := api.member(ctx, "POST", "/controller/network/%s/member/%s", networkId, nodeId, changes, &member)
+	if err != nil {
+		return nil, err
+	}
+
+	return member.toMemberInfo(), nil
+}
+
+func (api *LocalControllerApi) DeleteMember(ctx context.Context, networkId string, nodeId string) error {
+	return api.member(ctx, "DELETE", "/controller/network/%s/member/%s", networkId, nodeId, nil, nil)
+}
+
+// ListMembers fetches the list of member ids first and then every member one by one,
+// as the controller does not return member details in the list.
+func (api *LocalControllerApi) ListMembers(ctx context.Context, networkId string) ([]*MemberInfo, error) {
+	// controller maps member ids to their revisions
+	revisions := make(map[string]interface{})
+	err := api.network(ctx, "GET", "/controller/network/%s/member", networkId, nil, &revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*MemberInfo, 0, len(revisions))
+	for nodeId := range revisions {
+		var member localControllerMember
+		err = api.do(ctx, "GET", fmt.Sprintf("/controller/network/%s/member/%s", networkId, nodeId), nil, &member)
+		if IsNotFound(err) {
+			// member has been deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member.toMemberInfo())
+	}
+
+	return members, nil
+}
+
+// GetNetwork returns network config only, controller doesn't provide other NetworkInfo fields
+func (api *LocalControllerApi) GetNetwork(ctx context.Context, networkId string) (*NetworkInfo, error) {
+	var network localControllerNetwork
+	err := api.network(ctx, "GET", "/controller/network/%s", networkId, nil, &network)
+	if err != nil {
+		return nil, err
+	}
+
+	return network.toNetworkInfo(), nil
+}
+
+// UpdateNetwork changes network config. Controller has no network descriptions, so description is dropped.
+func (api *LocalControllerApi) UpdateNetwork(ctx context.Context, networkId string, patch *NetworkUpdate) (*NetworkInfo, error) {
+	// controller's network is flat, so config changes are sent at the top level
+	changes := patch.Config
+	if changes == nil {
+		changes = &NetworkConfigUpdate{}
+	}
+	var network localControllerNetwork
+	err := api.network(ctx, "POST", "/controller/network/%s", networkId, changes, &network)
+	if err != nil {
+		return nil, err
+	}
+
+	return network.toNetworkInfo(), nil
+}
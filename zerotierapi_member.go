@@ -0,0 +1,108 @@
+package main
+
+import "time"
+
+// MemberConfig is the part of member which is pushed to the node by the controller
+type MemberConfig struct {
+	ID                   string   `json:"id"`
+	Identity             string   `json:"identity"`
+	Authorized           bool     `json:"authorized"`
+	ActiveBridge         bool     `json:"activeBridge"`
+	NoAutoAssignIps      bool     `json:"noAutoAssignIps"`
+	SsoExempt            bool     `json:"ssoExempt"`
+	IpAssignments        []string `json:"ipAssignments"`
+	Capabilities         []int    `json:"capabilities"`
+	Tags                 [][2]int `json:"tags"` // pairs of tag id and value
+	Revision             int64    `json:"revision"`
+	CreationTime         int64    `json:"creationTime"` // milliseconds since epoch
+	LastAuthorizedTime   int64    `json:"lastAuthorizedTime"`
+	LastDeauthorizedTime int64    `json:"lastDeauthorizedTime"`
+	VMajor               int      `json:"vMajor"`
+	VMinor               int      `json:"vMinor"`
+	VRev                 int      `json:"vRev"`
+	VProto               int      `json:"vProto"`
+}
+
+// MemberInfo is the common member model returned by every ZeroTierApi implementation.
+// It follows ZeroTier Central's member JSON; backends lacking some fields leave them zero.
+// Use MemberUpdate to change a member.
+type MemberInfo struct {
+	ID                  string       `json:"id"`
+	Clock               int64        `json:"clock"`
+	NetworkID           string       `json:"networkId"`
+	NodeID              string       `json:"nodeId"`
+	ControllerID        string       `json:"controllerId"`
+	Hidden              bool         `json:"hidden"`
+	Name                string       `json:"name"`
+	Description         string       `json:"description"`
+	Config              MemberConfig `json:"config"`
+	Online              bool         `json:"online"`
+	LastOnline          int64        `json:"lastOnline"` // milliseconds since epoch
+	LastSeen            int64        `json:"lastSeen"`
+	PhysicalAddress     string       `json:"physicalAddress"`
+	ClientVersion       string       `json:"clientVersion"`
+	ProtocolVersion     int          `json:"protocolVersion"`
+	SupportsRulesEngine bool         `json:"supportsRulesEngine"`
+}
+
+// LastOnlineTime converts LastOnline to time.Time. Zero time means the member has never been online.
+func (m *MemberInfo) LastOnlineTime() time.Time {
+	if m.LastOnline == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.LastOnline*int64(time.Millisecond))
+}
+
+// MemberConfigUpdate holds editable fields of MemberConfig
+type MemberConfigUpdate struct {
+	Authorized      *bool     `json:"authorized,omitempty"`
+	ActiveBridge    *bool     `json:"activeBridge,omitempty"`
+	NoAutoAssignIps *bool     `json:"noAutoAssignIps,omitempty"`
+	SsoExempt       *bool     `json:"ssoExempt,omitempty"`
+	IpAssignments   *[]string `json:"ipAssignments,omitempty"`
+	Capabilities    *[]int    `json:"capabilities,omitempty"`
+	Tags            *[][2]int `json:"tags,omitempty"`
+}
+
+// MemberUpdate holds editable fields of MemberInfo
+type MemberUpdate struct {
+	Hidden      *bool               `json:"hidden,omitempty"`
+	Name        *string             `json:"name,omitempty"`
+	Description *string             `json:"description,omitempty"`
+	Config      *MemberConfigUpdate `json:"config,omitempty"`
+}
+
+// authMemberUpdate is the change AuthMember makes: authorizes member and makes it visible,
+// name and description are changed only if given
+func authMemberUpdate(shortName string, description string) *MemberUpdate {
+	patch := &MemberUpdate{
+		Hidden: boolPtr(false),
+		Config: &MemberConfigUpdate{
+			Authorized: boolPtr(true),
+		},
+	}
+	if len(shortName) > 0 {
+		patch.Name = stringPtr(shortName)
+	}
+	if len(description) > 0 {
+		patch.Description = stringPtr(description)
+	}
+	return patch
+}
+
+// unauthMemberUpdate is the change UnauthMemberByID makes
+func unauthMemberUpdate() *MemberUpdate {
+	return &MemberUpdate{
+		Config: &MemberConfigUpdate{
+			Authorized: boolPtr(false),
+		},
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,90 @@
+package main
+
+import "encoding/json"
+
+// IpAssignmentPool is a range of addresses ZeroTier assigns to members automatically
+type IpAssignmentPool struct {
+	IpRangeStart string `json:"ipRangeStart"`
+	IpRangeEnd   string `json:"ipRangeEnd"`
+}
+
+// NetworkRoute is a managed route pushed to members. Nil Via means the target is reachable directly in the network.
+type NetworkRoute struct {
+	Target string  `json:"target"`
+	Via    *string `json:"via"`
+}
+
+type V4AssignMode struct {
+	Zt bool `json:"zt"` // assign addresses from IpAssignmentPools
+}
+
+type V6AssignMode struct {
+	SixPlane bool `json:"6plane"`
+	Rfc4193  bool `json:"rfc4193"`
+	Zt       bool `json:"zt"` // assign addresses from IpAssignmentPools
+}
+
+type NetworkDNS struct {
+	Domain  string   `json:"domain"`
+	Servers []string `json:"servers"`
+}
+
+// NetworkConfig is the part of network which is pushed to members by the controller.
+// Rules, capabilities and tags are kept as raw JSON as the bot does not interpret them.
+type NetworkConfig struct {
+	ID                string             `json:"id"`
+	Name              string             `json:"name"`
+	Private           bool               `json:"private"`
+	CreationTime      int64              `json:"creationTime"` // milliseconds since epoch
+	LastModified      int64              `json:"lastModified"`
+	EnableBroadcast   bool               `json:"enableBroadcast"`
+	Mtu               int                `json:"mtu"`
+	MulticastLimit    int                `json:"multicastLimit"`
+	IpAssignmentPools []IpAssignmentPool `json:"ipAssignmentPools"`
+	Routes            []NetworkRoute     `json:"routes"`
+	V4AssignMode      V4AssignMode       `json:"v4AssignMode"`
+	V6AssignMode      V6AssignMode       `json:"v6AssignMode"`
+	DNS               NetworkDNS         `json:"dns"`
+	Rules             []json.RawMessage  `json:"rules"`
+	Capabilities      []json.RawMessage  `json:"capabilities"`
+	Tags              []json.RawMessage  `json:"tags"`
+}
+
+// NetworkInfo is the common network model returned by every ZeroTierApi implementation.
+// It follows ZeroTier Central's network JSON; backends lacking some fields leave them zero.
+// Use NetworkUpdate to change a network.
+type NetworkInfo struct {
+	ID                    string        `json:"id"`
+	Clock                 int64         `json:"clock"`
+	Description           string        `json:"description"`
+	RulesSource           string        `json:"rulesSource"`
+	OwnerID               string        `json:"ownerId"`
+	OnlineMemberCount     int           `json:"onlineMemberCount"`
+	AuthorizedMemberCount int           `json:"authorizedMemberCount"`
+	TotalMemberCount      int           `json:"totalMemberCount"`
+	Config                NetworkConfig `json:"config"`
+}
+
+// NetworkConfigUpdate holds editable fields of NetworkConfig
+type NetworkConfigUpdate struct {
+	Name              *string             `json:"name,omitempty"`
+	Private           *bool               `json:"private,omitempty"`
+	EnableBroadcast   *bool               `json:"enableBroadcast,omitempty"`
+	Mtu               *int                `json:"mtu,omitempty"`
+	MulticastLimit    *int                `json:"multicastLimit,omitempty"`
+	IpAssignmentPools *[]IpAssignmentPool `json:"ipAssignmentPools,omitempty"`
+	Routes            *[]NetworkRoute     `json:"routes,omitempty"`
+	V4AssignMode      *V4AssignMode       `json:"v4AssignMode,omitempty"`
+	V6AssignMode      *V6AssignMode       `json:"v6AssignMode,omitempty"`
+	DNS               *NetworkDNS         `json:"dns,omitempty"`
+}
+
+// NetworkUpdate holds editable fields of NetworkInfo
+type NetworkUpdate struct {
+	Description *string              `json:"description,omitempty"`
+	Config      *NetworkConfigUpdate `json:"config,omitempty"`
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Used when corresponding ZTApiOptions fields are not set
+const (
+	defaultZTMaxRetries = 3
+	defaultZTRateLimit  = 5.0 // requests per second
+	defaultZTBurst      = 10
+)
+
+// Backoff between retries is doubled starting from ztRetryBaseDelay up to ztRetryMaxDelay
+const (
+	ztRetryBaseDelay = 500 * time.Millisecond
+	ztRetryMaxDelay  = 10 * time.Second
+)
+
+// ZTUnavailableError is returned by ZeroTierApi calls when ZeroTier keeps failing after all retries
+// or asks to wait longer than the call is allowed to take.
+type ZTUnavailableError struct {
+	StatusCode int           // status of the last response, 0 if there was none
+	Attempts   int           // how many requests have been sent
+	RetryAfter time.Duration // how long ZeroTier asked to wait, 0 if it didn't
+	Err        error         // error of the last attempt if there was no response
+}
+
+func (e *ZTUnavailableError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("ZeroTier unavailable after %d attempt(s): %v", e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("ZeroTier unavailable after %d attempt(s): status %d", e.Attempts, e.StatusCode)
+}
+
+func (e *ZTUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRateLimited tells if ZeroTier has been refusing requests because of rate limit
+func (e *ZTUnavailableError) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// retryTransport is a http.RoundTripper that limits request rate (unless limiter is nil) and retries failed requests.
+// Responses with status 429 are retried for every method as they have not been processed,
+// network errors and 5xx only for idempotent ones.
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	limiter    *tokenBucket
+}
+
+// withRetries returns a copy of client which retries and rate-limits requests according to options.
+// Rate limit is Central's policy, requests to local controller are not limited: it serves members one by one.
+func withRetries(client *http.Client, options ZTApiOptions) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxRetries := options.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultZTMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+	var limiter *tokenBucket
+	if options.Backend != ZTBackendLocal {
+		rateLimit := options.RateLimit
+		if rateLimit <= 0 {
+			rateLimit = defaultZTRateLimit
+		}
+		burst := options.Burst
+		if burst <= 0 {
+			burst = defaultZTBurst
+		}
+		limiter = newTokenBucket(rateLimit, burst)
+	}
+
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &retryTransport{
+		next:       next,
+		maxRetries: maxRetries,
+		limiter:    limiter,
+	}
+	return &c
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	idempotent := isIdempotent(req)
+	unavailable := &ZTUnavailableError{}
+
+	for attempt := 0; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			var err error
+			if attemptReq, err = rewindRequest(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		unavailable.Attempts = attempt + 1
+		unavailable.RetryAfter = 0
+
+		switch {
+		case err != nil:
+			if !idempotent || ctx.Err() != nil {
+				return nil, err
+			}
+			unavailable.StatusCode, unavailable.Err = 0, err
+		case resp.StatusCode == http.StatusTooManyRequests ||
+			(idempotent && resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented):
+			unavailable.StatusCode, unavailable.Err = resp.StatusCode, nil
+			unavailable.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			// drain body so that connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		default:
+			return resp, nil
+		}
+
+		if attempt >= t.maxRetries {
+			return nil, unavailable
+		}
+
+		delay := backoffDelay(attempt)
+		if unavailable.RetryAfter > delay {
+			delay = unavailable.RetryAfter
+		}
+		// no point in waiting if the call will be cancelled anyway
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, unavailable
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Same rule as net/http uses: safe methods and requests with idempotency key are idempotent
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	_, hasXKey := req.Header["X-Idempotency-Key"]
+	return hasKey || hasXKey
+}
+
+// rewindRequest makes a copy of req with fresh body to send it once again
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry %s %s: request body is not rewindable", req.Method, req.URL.Path)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+// backoffDelay is exponential backoff with jitter: random value between half and full delay
+func backoffDelay(attempt int) time.Duration {
+	delay := ztRetryMaxDelay
+	if attempt < 16 {
+		if d := ztRetryBaseDelay << uint(attempt); d < delay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// parseRetryAfter supports both forms of Retry-After: seconds and HTTP date. Returns 0 if header is absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// tokenBucket allows `burst` requests at once and then `rate` requests per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
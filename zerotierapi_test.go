@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeLocalController serves member list and members of testNetworkId the way zerotier-one controller does
+func fakeLocalController(t *testing.T, members int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	prefix := "/controller/network/" + testNetworkId + "/member"
+	mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		revisions := make(map[string]int, members)
+		for i := 0; i < members; i++ {
+			revisions[fmt.Sprintf("%010x", i)] = 1
+		}
+		_ = json.NewEncoder(w).Encode(revisions)
+	})
+	mux.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		nodeId := r.URL.Path[len(prefix)+1:]
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": nodeId, "address": nodeId, "nwid": testNetworkId, "authorized": true,
+			"vMajor": -1, "vMinor": -1, "vRev": -1,
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Local controller is asked for every member separately, Central's rate limit must not apply to it
+func TestLocalControllerListMembersIsNotRateLimited(t *testing.T) {
+	const members = 100
+	server := fakeLocalController(t, members)
+	ztApi, err := NewZTApi(ZTApiOptions{Backend: ZTBackendLocal, Token: testToken, BaseUrl: server.URL, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with default rate limit of Central listing would take about 20 seconds
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	list, err := ztApi.ListMembers(ctx, testNetworkId)
+	if err != nil {
+		t.Fatalf("ListMembers() error = %v", err)
+	}
+	if len(list) != members {
+		t.Errorf("ListMembers() returned %d members, want %d", len(list), members)
+	}
+}
+
+func TestCentralIsRateLimited(t *testing.T) {
+	fake := NewFakeCentral(testToken)
+	fake.AddNetwork(testNetworkId)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	ztApi, err := NewZTApi(ZTApiOptions{Token: testToken, BaseUrl: server.URL, RateLimit: 10, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := ztApi.ListMembers(context.Background(), testNetworkId); err != nil {
+			t.Fatalf("ListMembers() error = %v", err)
+		}
+	}
+	// the first request is allowed at once, the others wait for 100ms each
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("3 requests took %s, want them rate limited", elapsed)
+	}
+}
+
+func TestCentralMemberRequestBodies(t *testing.T) {
+	const nodeId = "0123456789"
+	tests := []struct {
+		name string
+		call func(ztApi ZeroTierApi) error
+		want string
+	}{
+		{
+			name: "unauth sends authorized only",
+			call: func(ztApi ZeroTierApi) error {
+				return ztApi.UnauthMemberByID(context.Background(), testNetworkId, nodeId)
+			},
+			want: `{"config":{"authorized":false}}`,
+		},
+		{
+			name: "auth unhides member",
+			call: func(ztApi ZeroTierApi) error {
+				return ztApi.AuthMember(context.Background(), testNetworkId, nodeId, "laptop", "added by 1")
+			},
+			want: `{"hidden":false,"name":"laptop","description":"added by 1","config":{"authorized":true}}`,
+		},
+		{
+			name: "auth without name and description",
+			call: func(ztApi ZeroTierApi) error {
+				return ztApi.AuthMember(context.Background(), testNetworkId, nodeId, "", "")
+			},
+			want: `{"hidden":false,"config":{"authorized":true}}`,
+		},
+		{
+			name: "update leaves out unset fields",
+			call: func(ztApi ZeroTierApi) error {
+				_, err := ztApi.UpdateMember(context.Background(), testNetworkId, nodeId, &MemberUpdate{Name: stringPtr("nas")})
+				return err
+			},
+			want: `{"name":"nas"}`,
+		},
+		{
+			name: "update sends set ip assignments and hidden",
+			call: func(ztApi ZeroTierApi) error {
+				_, err := ztApi.UpdateMember(context.Background(), testNetworkId, nodeId, &MemberUpdate{
+					Hidden: boolPtr(true),
+					Config: &MemberConfigUpdate{IpAssignments: &[]string{"10.0.0.2"}},
+				})
+				return err
+			},
+			want: `{"hidden":true,"config":{"ipAssignments":["10.0.0.2"]}}`,
+		},
+		{
+			name: "update sends empty ip assignments",
+			call: func(ztApi ZeroTierApi) error {
+				_, err := ztApi.UpdateMember(context.Background(), testNetworkId, nodeId, &MemberUpdate{
+					Config: &MemberConfigUpdate{IpAssignments: &[]string{}},
+				})
+				return err
+			},
+			want: `{"config":{"ipAssignments":[]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFakeCentral(testToken)
+			fake.SetMember(testNetworkId, nodeId, map[string]interface{}{
+				"hidden": true,
+				"config": map[string]interface{}{"authorized": true, "ipAssignments": []interface{}{"10.0.0.1"}},
+			})
+			if err := tt.call(newTestZTApi(t, fake)); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := fake.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("fake got %d requests, want 1", len(requests))
+			}
+			if requests[0].Method != http.MethodPost || requests[0].Path != "/network/"+testNetworkId+"/member/"+nodeId {
+				t.Errorf("request = %s %s, want POST to the member", requests[0].Method, requests[0].Path)
+			}
+			if body := string(requests[0].Body); body != tt.want {
+				t.Errorf("request body = %s, want %s", body, tt.want)
+			}
+		})
+	}
+}
+
+// Fields left out of the request keep their values in Central
+func TestCentralUnauthKeepsOtherFields(t *testing.T) {
+	const nodeId = "0123456789"
+	fake := NewFakeCentral(testToken)
+	fake.SetMember(testNetworkId, nodeId, map[string]interface{}{
+		"hidden": true,
+		"name":   "laptop",
+		"config": map[string]interface{}{"authorized": true, "ipAssignments": []interface{}{"10.0.0.1"}},
+	})
+	if err := newTestZTApi(t, fake).UnauthMemberByID(context.Background(), testNetworkId, nodeId); err != nil {
+		t.Fatal(err)
+	}
+
+	member := fake.Member(testNetworkId, nodeId)
+	config := member["config"].(map[string]interface{})
+	if config["authorized"] != false {
+		t.Errorf("authorized = %v, want false", config["authorized"])
+	}
+	if ips, _ := config["ipAssignments"].([]interface{}); len(ips) != 1 || ips[0] != "10.0.0.1" {
+		t.Errorf("ipAssignments = %v, want [10.0.0.1]", config["ipAssignments"])
+	}
+	if member["hidden"] != true || member["name"] != "laptop" {
+		t.Errorf("hidden = %v, name = %v, want true and laptop", member["hidden"], member["name"])
+	}
+}